@@ -0,0 +1,241 @@
+// Command fakeoidc is a minimal OpenID provider for developing and testing social
+// sign-in locally. It approves every authorization request without a login page and
+// issues an EdDSA-signed ID token for a configurable rider.
+//
+//	go run ./cmd/fakeoidc -addr :9000 -email rider@example.com
+//
+// Then point the gateway at it:
+//
+//	OIDC_PROVIDERS=fake
+//	OIDC_FAKE_ISSUER=http://localhost:9000
+//	OIDC_FAKE_CLIENT_ID=loop-local
+//	OIDC_FAKE_REDIRECT_URL=http://localhost:8081/api/auth/oidc/fake/callback
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+)
+
+const (
+	signingKeyID = "fake-oidc"
+	codeTTL      = time.Minute
+	idTokenTTL   = 10 * time.Minute
+)
+
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+type fakeIssuer struct {
+	issuer        string
+	subject       string
+	email         string
+	emailVerified bool
+	name          string
+
+	key     jwtlib.Key
+	keyring *jwtlib.Keyring
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, must match how the gateway reaches this server")
+	subject := flag.String("sub", "fake-user-1", "subject of issued ID tokens")
+	email := flag.String("email", "rider@example.com", "email of issued ID tokens")
+	emailVerified := flag.Bool("email-verified", true, "email_verified claim of issued ID tokens")
+	name := flag.String("name", "Fake Rider", "name of issued ID tokens")
+	flag.Parse()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal("Could not generate signing key:", err)
+	}
+
+	key := jwtlib.Key{ID: signingKeyID, Algorithm: jwtlib.AlgEdDSA, PrivateKey: priv}
+	keyring, err := jwtlib.NewKeyring(signingKeyID, key)
+	if err != nil {
+		log.Fatal("Could not build keyring:", err)
+	}
+
+	f := &fakeIssuer{
+		issuer:        *issuer,
+		subject:       *subject,
+		email:         *email,
+		emailVerified: *emailVerified,
+		name:          *name,
+		key:           key,
+		keyring:       keyring,
+		codes:         make(map[string]authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.discoveryHandler)
+	mux.HandleFunc("/jwks", f.jwksHandler)
+	mux.HandleFunc("/authorize", f.authorizeHandler)
+	mux.HandleFunc("/token", f.tokenHandler)
+
+	fmt.Println("Fake OIDC issuer", *issuer, "listening on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (f *fakeIssuer) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                f.issuer,
+		"authorization_endpoint":                f.issuer + "/authorize",
+		"token_endpoint":                        f.issuer + "/token",
+		"jwks_uri":                              f.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwtlib.AlgEdDSA},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (f *fakeIssuer) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, f.keyring.JWKS())
+}
+
+// authorizeHandler approves the request immediately and redirects back with a code,
+// honouring response_mode=form_post like Apple does
+func (f *fakeIssuer) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("response_type") != "code" || redirectURI == "" || q.Get("client_id") == "" {
+		http.Error(w, "response_type=code, client_id and redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+
+	f.mu.Lock()
+	f.codes[code] = authorization{
+		clientID:      q.Get("client_id"),
+		redirectURI:   redirectURI,
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	f.mu.Unlock()
+
+	params := url.Values{}
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+
+	if q.Get("response_mode") == "form_post" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<html><body onload="document.forms[0].submit()"><form method="post" action="%s">`, html.EscapeString(redirectURI))
+		for name := range params {
+			fmt.Fprintf(w, `<input type="hidden" name="%s" value="%s">`, name, html.EscapeString(params.Get(name)))
+		}
+		fmt.Fprint(w, `</form></body></html>`)
+		return
+	}
+
+	http.Redirect(w, r, redirectURI+"?"+params.Encode(), http.StatusFound)
+}
+
+func (f *fakeIssuer) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	f.mu.Lock()
+	auth, ok := f.codes[code]
+	delete(f.codes, code)
+	f.mu.Unlock()
+
+	if r.PostForm.Get("grant_type") != "authorization_code" || !ok || time.Now().After(auth.expiresAt) {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	if r.PostForm.Get("client_id") != auth.clientID || r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.codeChallenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            f.issuer,
+		"sub":            f.subject,
+		"aud":            auth.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(idTokenTTL).Unix(),
+		"nonce":          auth.nonce,
+		"email":          f.email,
+		"email_verified": f.emailVerified,
+		"name":           f.name,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = f.key.ID
+	idToken, err := token.SignedString(f.key.PrivateKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Could not generate random value:", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
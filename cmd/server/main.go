@@ -1,17 +1,33 @@
 package main
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	pb "ravigill/rider-grpc-server/proto"
 
+	"github.com/loop/backend/rider-auth/lib/authn"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/lib/mfa"
+	grpcmw "github.com/loop/backend/rider-auth/lib/middleware"
+	"github.com/loop/backend/rider-auth/lib/mtls"
+	"github.com/loop/backend/rider-auth/rest/internals/apikeys"
 	"github.com/loop/backend/rider-auth/rest/internals/configs"
 	"github.com/loop/backend/rider-auth/rest/internals/handlers"
+	"github.com/loop/backend/rider-auth/rest/internals/lockout"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+	"github.com/loop/backend/rider-auth/rest/internals/notify"
+	"github.com/loop/backend/rider-auth/rest/internals/oidc"
+	"github.com/loop/backend/rider-auth/rest/internals/otp"
 	"github.com/loop/backend/rider-auth/rest/internals/routes"
+	"github.com/loop/backend/rider-auth/rest/internals/sessions"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -31,22 +47,126 @@ func NewHTTPServer(authClient pb.AuthServiceClient, paymentClient pb.PaymentServ
 
 func (s *HTTPServer) Start(port string) {
 
-	secretKey := os.Getenv("ACCESS_TOKEN_SECRET_KEY")
-	if secretKey == "" {
-		log.Fatal("ACCESS_TOKEN_SECRET_KEY environment variable is required")
+	keyring, err := jwtlib.LoadKeyringFromEnv()
+	if err != nil {
+		log.Fatal("Could not load JWT keyring:", err)
+	}
+	if err := checkGatewayKeyring(keyring); err != nil {
+		log.Fatal("Invalid JWT keyring:", err)
+	}
+
+	revocations, err := newRevocationStore()
+	if err != nil {
+		log.Fatal("Could not open revocation store:", err)
+	}
+	verifyOpts, err := jwtlib.VerifyOptionsFromEnv()
+	if err != nil {
+		log.Fatal("Invalid JWT verification settings:", err)
+	}
+	sessionStore, err := newSessionStore()
+	if err != nil {
+		log.Fatal("Could not open session store:", err)
+	}
+	jwtAuthenticator := middleware.JWTAuthenticator(keyring, revocations, verifyOpts...)
+	jwtMiddleware := middleware.Authenticate(jwtAuthenticator, sessionStore)
+
+	appBaseURL := os.Getenv("APP_BASE_URL")
+	if appBaseURL == "" {
+		appBaseURL = "http://localhost:5173"
+	}
+
+	notifier := newNotifier()
+
+	lockoutConfig, err := lockout.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid login lockout settings:", err)
+	}
+	loginGuard := lockout.NewGuard(lockout.NewMemoryStore(), lockoutConfig)
+	// Every reset email counts as a failure, so an inbox gets at most 3 an hour
+	resetGuard := lockout.NewGuard(lockout.NewMemoryStore(), lockout.Config{
+		MaxFailuresPerEmail: 3,
+		MaxFailuresPerIP:    20,
+		FailureWindow:       time.Hour,
+		Lockout:             time.Hour,
+	})
+
+	mfaStore, err := newMFAStore()
+	if err != nil {
+		log.Fatal("Could not open MFA store:", err)
+	}
+
+	otpManager, err := otp.NewManager(otp.NewMemoryStore(), otpSecret())
+	if err != nil {
+		log.Fatal("Could not set up OTP login:", err)
 	}
 
-	authHandler := handlers.NewAuthService(s.authClient)
-	authRoutes := routes.NewAuthRoutes(s.mux, authHandler)
+	authHandler := handlers.NewAuthService(s.authClient, keyring, revocations, notifier, appBaseURL, mfaStore, loginGuard, resetGuard, sessionStore, otpManager)
+	authRoutes := routes.NewAuthRoutes(s.mux, authHandler, jwtMiddleware)
 	authRoutes.Register()
 
-	paymentHandler := handlers.NewPaymentService(s.paymentClient)
-	paymentRoutes := routes.NewPaymentRoutes(s.mux, paymentHandler, secretKey)
+	otpHandler := handlers.NewOTPService(authHandler, notifier)
+	otpRoutes := routes.NewOTPRoutes(s.mux, otpHandler)
+	otpRoutes.Register()
+
+	oidcProviders, err := oidc.LoadProviders()
+	if err != nil {
+		log.Fatal("Could not configure OIDC sign-in:", err)
+	}
+	oidcHandler := handlers.NewOIDCService(authHandler, oidcProviders)
+	oidcRoutes := routes.NewOIDCRoutes(s.mux, oidcHandler)
+	oidcRoutes.Register()
+
+	deletionGrace := handlers.DefaultDeletionGracePeriod
+	if grace := os.Getenv("ACCOUNT_DELETION_GRACE"); grace != "" {
+		deletionGrace, err = time.ParseDuration(grace)
+		if err != nil {
+			log.Fatal("Invalid ACCOUNT_DELETION_GRACE:", err)
+		}
+	}
+	accountHandler := handlers.NewAccountService(authHandler, s.paymentClient, deletionGrace)
+	accountRoutes := routes.NewAccountRoutes(s.mux, accountHandler, jwtMiddleware)
+	accountRoutes.Register()
+
+	apiKeyStore, err := newAPIKeyStore()
+	if err != nil {
+		log.Fatal("Could not open API key store:", err)
+	}
+	apiKeyHandler := handlers.NewAPIKeyService(apiKeyStore)
+	apiKeyRoutes := routes.NewAPIKeyRoutes(s.mux, apiKeyHandler, jwtMiddleware)
+	apiKeyRoutes.Register()
+	// Partners may use an API key wherever the route allows it instead of a rider login
+	apiKeyMiddleware := middleware.Authenticate(authn.Chain(middleware.APIKeyAuthenticator(apiKeyStore, keyring), jwtAuthenticator), sessionStore)
+
+	introspectionTTL := handlers.DefaultIntrospectionCacheTTL
+	if ttl := os.Getenv("INTROSPECTION_CACHE_TTL"); ttl != "" {
+		introspectionTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatal("Invalid INTROSPECTION_CACHE_TTL:", err)
+		}
+	}
+	introspectionHandler := handlers.NewIntrospectionService(keyring, revocations, introspectionTTL, verifyOpts...)
+	clientMiddleware := middleware.Authenticate(middleware.APIKeyAuthenticator(apiKeyStore, keyring), nil)
+	introspectionRoutes := routes.NewIntrospectionRoutes(s.mux, introspectionHandler, clientMiddleware)
+	introspectionRoutes.Register()
+
+	paymentHandler := handlers.NewPaymentService(s.paymentClient, apiKeyStore)
+	requireVerifiedEmail := os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+	paymentRoutes := routes.NewPaymentRoutes(s.mux, paymentHandler, apiKeyMiddleware, requireVerifiedEmail)
 	paymentRoutes.Register()
 
 	fmt.Println("Server is running on PORT" + " " + port)
 
-	err := http.ListenAndServe(""+port, corsMiddleware(s.mux))
+	// Webhooks authenticate with their own signatures rather than rider cookies
+	csrf := middleware.NewCSRF(csrfSecret(), "/api/webhooks/")
+
+	trustedProxies, err := middleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" && len(trustedProxies) == 0 {
+		log.Fatal("TRUST_PROXY_HEADERS is replaced by TRUSTED_PROXIES, the addresses of the proxies in front of the gateway")
+	}
+	err = http.ListenAndServe(""+port, corsMiddleware(middleware.ClientIP(trustedProxies)(middleware.RequestMetadata(csrf.Middleware(s.mux)))))
 
 	fmt.Println(err)
 }
@@ -63,13 +183,25 @@ func main() {
 		port = "8081"
 	}
 
-	authConn, err := grpc.NewClient("localhost:50052", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// Handlers pass the request context; the interceptors forward the verified
+	// credential, request ID, client IP and user agent recorded in it
+	transportCreds, err := grpcTransportCredentials()
+	if err != nil {
+		log.Fatal("Could not set up gRPC TLS:", err)
+	}
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(transportCreds),
+		grpc.WithUnaryInterceptor(grpcmw.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(grpcmw.StreamClientInterceptor()),
+	}
+
+	authConn, err := grpc.NewClient(envOr("AUTH_GRPC_ADDR", "localhost:50052"), dialOptions...)
 	if err != nil {
 		log.Fatal("Could not connect to Auth gRPC:", err)
 	}
 	authClient := pb.NewAuthServiceClient(authConn)
 
-	paymentConn, err := grpc.NewClient("localhost:50053", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	paymentConn, err := grpc.NewClient(envOr("PAYMENT_GRPC_ADDR", "localhost:50053"), dialOptions...)
 	if err != nil {
 		log.Fatal("Could not connect to Payment gRPC:", err)
 	}
@@ -79,12 +211,147 @@ func main() {
 	httpServer.Start(port)
 }
 
+// grpcTransportCredentials uses TLS for the backend connections when GRPC_TLS_CA_FILE is
+// set, and mutual TLS when GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE are set too. The
+// files are re-read when they change. Without a CA the connections are plaintext, which
+// is only suitable when the backends run on the same host.
+func grpcTransportCredentials() (credentials.TransportCredentials, error) {
+	caFile := os.Getenv("GRPC_TLS_CA_FILE")
+	// Methods such as IssueTokens only accept the gateway's certificate
+	if os.Getenv("GRPC_TLS_CERT_FILE") == "" {
+		log.Println("GRPC_TLS_CERT_FILE is not set: backend methods that only accept the gateway's service identity will refuse its calls")
+	}
+	if caFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	return mtls.ClientCredentials(mtls.Config{
+		CertFile:   os.Getenv("GRPC_TLS_CERT_FILE"),
+		KeyFile:    os.Getenv("GRPC_TLS_KEY_FILE"),
+		CAFile:     caFile,
+		ServerName: os.Getenv("GRPC_TLS_SERVER_NAME"),
+	})
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// newRevocationStore uses a file-backed store when REVOCATION_STORE_FILE is set so that
+// revocations survive restarts and can be shared with local gRPC services
+func newRevocationStore() (jwtlib.RevocationStore, error) {
+	if path := os.Getenv("REVOCATION_STORE_FILE"); path != "" {
+		return jwtlib.NewFileRevocationStore(path)
+	}
+	return jwtlib.NewMemoryRevocationStore(), nil
+}
+
+// gatewayTokenTypes are the tokens the gateway mints itself: emailed links and the
+// partner tokens behind API keys. Everything else is signed by the auth service.
+var gatewayTokenTypes = []jwtlib.TokenType{
+	jwtlib.TokenTypePasswordReset,
+	jwtlib.TokenTypeEmailVerify,
+	jwtlib.TokenTypeEmailChange,
+	jwtlib.TokenTypeCancelDelete,
+	jwtlib.TokenTypePartner,
+}
+
+// checkGatewayKeyring refuses a keyring that would let the gateway sign rider sessions.
+// The active key must be the gateway's own, limited with token_types to the types it
+// mints, and the auth service's keys may only be listed to verify.
+func checkGatewayKeyring(keyring *jwtlib.Keyring) error {
+	active, err := keyring.Active()
+	if err != nil {
+		return err
+	}
+	for _, tokenType := range gatewayTokenTypes {
+		if !active.Allows(tokenType) {
+			return fmt.Errorf("active kid %q must allow %s tokens", active.ID, tokenType)
+		}
+	}
+	for _, tokenType := range []jwtlib.TokenType{jwtlib.TokenTypeAccess, jwtlib.TokenTypeRefresh, jwtlib.TokenTypeMFAChallenge} {
+		if keyring.CanSign(tokenType) {
+			return fmt.Errorf("a key can sign %s tokens; give the gateway's key token_types and list the auth service's keys by public_key_file", tokenType)
+		}
+	}
+	return nil
+}
+
+// newMFAStore opens MFA_STORE_FILE. There is no in-memory fallback: a restart would
+// silently turn off every rider's second factor.
+func newMFAStore() (mfa.Store, error) {
+	path := os.Getenv("MFA_STORE_FILE")
+	if path == "" {
+		return nil, errors.New("MFA_STORE_FILE is not set; two-factor enrollments must survive restarts")
+	}
+	return mfa.NewFileStore(path)
+}
+
+// newSessionStore keeps sessions in SESSIONS_STORE_FILE when it is set. Without it the
+// session list starts empty after a restart and a lost device cannot be signed out.
+func newSessionStore() (sessions.Store, error) {
+	if path := os.Getenv("SESSIONS_STORE_FILE"); path != "" {
+		return sessions.NewFileStore(path)
+	}
+	return sessions.NewMemoryStore(), nil
+}
+
+// newAPIKeyStore keeps API keys in APIKEYS_FILE when it is set. Without it keys live in
+// memory and partners need new ones after every restart.
+func newAPIKeyStore() (apikeys.Store, error) {
+	if path := os.Getenv("APIKEYS_FILE"); path != "" {
+		return apikeys.NewFileStore(path)
+	}
+	return apikeys.NewMemoryStore(), nil
+}
+
+// newNotifier appends outgoing emails and SMS to NOTIFY_FILE when it is set and logs
+// them otherwise
+func newNotifier() notify.Notifier {
+	if path := os.Getenv("NOTIFY_FILE"); path != "" {
+		return notify.NewFileNotifier(path)
+	}
+	return notify.NewLogNotifier()
+}
+
+// otpSecret keys the hashes of stored login codes. Without OTP_SECRET a random key is
+// used, which is fine for the in-memory store since codes die with the process anyway.
+func otpSecret() []byte {
+	if secret := os.Getenv("OTP_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal("Could not generate OTP secret:", err)
+	}
+	return secret
+}
+
+// csrfSecret signs CSRF tokens. Without CSRF_SECRET a random key is used, so tokens
+// are reissued after a restart and several replicas would reject each other's tokens.
+func csrfSecret() []byte {
+	if secret := os.Getenv("CSRF_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal("Could not generate CSRF secret:", err)
+	}
+	return secret
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-CSRF-Token, X-Request-ID")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,204 @@
+package apikeys
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		// present turns the issued plaintext into the presented one
+		present func(plaintext string) string
+		revoke  bool
+		expired bool
+		wantErr error
+	}{
+		{
+			name:    "valid key",
+			present: func(p string) string { return p },
+		},
+		{
+			name:    "wrong secret",
+			present: func(p string) string { return p[:len(p)-1] + "x" },
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "missing prefix",
+			present: func(p string) string { return strings.TrimPrefix(p, keyPrefix) },
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "unknown ID",
+			present: func(p string) string { return keyPrefix + "0000000000000000_secret" },
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "revoked key",
+			present: func(p string) string { return p },
+			revoke:  true,
+			wantErr: ErrRevokedKey,
+		},
+		{
+			name:    "expired key",
+			present: func(p string) string { return p },
+			expired: true,
+			wantErr: ErrExpiredKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expiresAt := now.Add(time.Hour)
+			if tt.expired {
+				expiresAt = now.Add(-time.Second)
+			}
+			plaintext, key, err := Generate("Acme Travel", "bookings", []string{ScopeCheckoutCreate}, []string{"rider-1"}, expiresAt)
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+			if strings.Contains(string(key.Hash), plaintext) {
+				t.Fatal("stored key contains the plaintext")
+			}
+
+			store := NewMemoryStore()
+			if err := store.Create(key); err != nil {
+				t.Fatal(err)
+			}
+			if tt.revoke {
+				if err := store.Revoke(key.ID, now); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := Authenticate(store, tt.present(plaintext), now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.ID != key.ID {
+				t.Errorf("Authenticate() key = %s, want %s", got.ID, key.ID)
+			}
+			if stored, _, _ := store.Get(key.ID); stored.LastUsedAt == nil || !stored.LastUsedAt.Equal(now) {
+				t.Errorf("LastUsedAt = %v, want %v", stored.LastUsedAt, now)
+			}
+		})
+	}
+}
+
+func TestGenerateRejectsUnknownScope(t *testing.T) {
+	if _, _, err := Generate("Acme Travel", "bookings", []string{"admin"}, nil, time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("Generate() accepted an unknown scope")
+	}
+}
+
+func TestKeyCanActFor(t *testing.T) {
+	key := Key{RiderIDs: []string{"rider-1", "rider-2"}}
+
+	tests := []struct {
+		riderID string
+		want    bool
+	}{
+		{riderID: "rider-1", want: true},
+		{riderID: "rider-3", want: false},
+		{riderID: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := key.CanActFor(tt.riderID); got != tt.want {
+			t.Errorf("CanActFor(%q) = %v, want %v", tt.riderID, got, tt.want)
+		}
+	}
+	if (Key{}).CanActFor("rider-1") {
+		t.Error("key without riders can act for rider-1")
+	}
+}
+
+func TestFileStoreSharedBetweenInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	first, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes in quick succession can share a modification time
+	for _, id := range []string{"key-1", "key-2"} {
+		if err := first.Create(Key{ID: id, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, found, err := second.Get(id); err != nil || !found {
+			t.Fatalf("second.Get(%q) = %v, %v, want the key", id, found, err)
+		}
+	}
+
+	if err := second.Revoke("key-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if key, _, err := first.Get("key-1"); err != nil || key.RevokedAt == nil {
+		t.Errorf("first.Get() after revoke = %+v, %v, want revoked", key, err)
+	}
+}
+
+func TestFileStoreConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	stores := make([]*FileStore, 2)
+	for i := range stores {
+		store, err := NewFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores[i] = store
+	}
+	if err := stores[0].Create(Key{ID: "busy", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// One instance keeps touching a key while the other creates keys and revokes the
+	// busy one; none of its writes may be lost
+	var wg sync.WaitGroup
+	start := time.Now()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := stores[0].Touch("busy", start.Add(time.Duration(i+1)*touchInterval)); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := stores[1].Create(Key{ID: fmt.Sprintf("key-%d", i), ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := stores[1].Revoke("busy", time.Now()); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	keys, err := stores[0].List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 21 {
+		t.Errorf("List() returned %d keys, want 21", len(keys))
+	}
+	if busy, _, _ := stores[0].Get("busy"); busy.RevokedAt == nil {
+		t.Error("revoke of the busy key was lost")
+	}
+}
@@ -0,0 +1,267 @@
+package apikeys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/loop/backend/rider-auth/lib/filelock"
+)
+
+// touchInterval limits how often LastUsedAt is rewritten for a busy key
+const touchInterval = time.Minute
+
+// Store keeps API keys. It is an interface so several gateway replicas can share a
+// backend; MemoryStore serves a single instance and FileStore survives restarts.
+type Store interface {
+	Create(key Key) error
+	Get(id string) (Key, bool, error)
+	// List returns the owner's keys, or every key for an empty owner, newest first
+	List(owner string) ([]Key, error)
+	// Touch records that the key was used at the given time
+	Touch(id string, at time.Time) error
+	Revoke(id string, at time.Time) error
+}
+
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]Key
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		keys: make(map[string]Key),
+	}
+}
+
+func (s *MemoryStore) Create(key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (Key, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	return key, ok, nil
+}
+
+func (s *MemoryStore) List(owner string) ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return listKeys(s.keys, owner), nil
+}
+
+func (s *MemoryStore) Touch(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	touchKey(s.keys, id, at)
+	return nil
+}
+
+func (s *MemoryStore) Revoke(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return revokeKey(s.keys, id, at)
+}
+
+// FileStore persists keys as a JSON file. The file is re-read whenever it is replaced
+// or modified, so keys created by another process are picked up, and writers hold a
+// lock on a ".lock" file next to it so replicas never save over each other's changes.
+type FileStore struct {
+	mu     sync.Mutex
+	path   string
+	loaded os.FileInfo // the file as last read or written
+	keys   map[string]Key
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path: path,
+		keys: make(map[string]Key),
+	}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) Create(key Key) error {
+	return s.update(func(keys map[string]Key) (bool, error) {
+		keys[key.ID] = key
+		return true, nil
+	})
+}
+
+func (s *FileStore) Get(id string) (Key, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return Key{}, false, err
+	}
+	key, ok := s.keys[id]
+	return key, ok, nil
+}
+
+func (s *FileStore) List(owner string) ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return listKeys(s.keys, owner), nil
+}
+
+func (s *FileStore) Touch(id string, at time.Time) error {
+	return s.update(func(keys map[string]Key) (bool, error) {
+		return touchKey(keys, id, at), nil
+	})
+}
+
+func (s *FileStore) Revoke(id string, at time.Time) error {
+	return s.update(func(keys map[string]Key) (bool, error) {
+		if err := revokeKey(keys, id, at); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// update applies change to the current keys under the file lock and saves them if
+// change reports that it modified them. Reloading inside the lock means a replica
+// touching a key cannot write back a copy that predates another replica's revoke.
+func (s *FileStore) update(change func(keys map[string]Key) (bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := filelock.Lock(s.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock API key file: %w", err)
+	}
+	defer unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+	changed, err := change(s.keys)
+	if err != nil || !changed {
+		return err
+	}
+	return s.save()
+}
+
+// reload reads the file if it changed since the last read. A missing file is an empty store.
+func (s *FileStore) reload() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat API key file: %w", err)
+	}
+
+	// Each save renames a new file into place, so a different file with the same
+	// modification time (timestamps are coarser than writes) still counts as a change
+	if s.loaded != nil && os.SameFile(info, s.loaded) && info.ModTime().Equal(s.loaded.ModTime()) && info.Size() == s.loaded.Size() {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read API key file: %w", err)
+	}
+
+	keys := make(map[string]Key)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return fmt.Errorf("failed to parse API key file: %w", err)
+		}
+	}
+
+	s.keys = keys
+	s.loaded = info
+	return nil
+}
+
+// save writes the store to a temporary file and renames it over the original
+func (s *FileStore) save() error {
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".apikeys-*")
+	if err != nil {
+		return fmt.Errorf("failed to write API key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write API key file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write API key file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write API key file: %w", err)
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.loaded = info
+	}
+	return nil
+}
+
+func listKeys(keys map[string]Key, owner string) []Key {
+	var list []Key
+	for _, key := range keys {
+		if owner == "" || key.Owner == owner {
+			list = append(list, key)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// touchKey reports whether LastUsedAt changed
+func touchKey(keys map[string]Key, id string, at time.Time) bool {
+	key, ok := keys[id]
+	if !ok || (key.LastUsedAt != nil && at.Sub(*key.LastUsedAt) < touchInterval) {
+		return false
+	}
+
+	key.LastUsedAt = &at
+	keys[id] = key
+	return true
+}
+
+func revokeKey(keys map[string]Key, id string, at time.Time) error {
+	key, ok := keys[id]
+	if !ok {
+		return ErrInvalidKey
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &at
+		keys[id] = key
+	}
+	return nil
+}
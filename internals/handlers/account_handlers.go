@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	pb "ravigill/rider-grpc-server/proto"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/lib/mfa"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+	"github.com/loop/backend/rider-auth/rest/internals/notify"
+)
+
+// DefaultDeletionGracePeriod is how long a deleted account can still be restored
+const DefaultDeletionGracePeriod = 30 * 24 * time.Hour
+
+// AccountService answers privacy requests: exporting a rider's data and deleting
+// their account. It needs the payment service as well as the auth service, so it
+// wraps the AuthService rather than growing it.
+type AccountService struct {
+	auth          *AuthService
+	paymentClient pb.PaymentServiceClient
+	deletionGrace time.Duration
+}
+
+func NewAccountService(auth *AuthService, paymentClient pb.PaymentServiceClient, deletionGrace time.Duration) *AccountService {
+	return &AccountService{
+		auth:          auth,
+		paymentClient: paymentClient,
+		deletionGrace: deletionGrace,
+	}
+}
+
+// ExportRiderHandler returns everything the gateway can collect about the logged in
+// rider as JSON, or as a ZIP of JSON files with ?format=zip. A failing upstream fails
+// the whole export rather than returning an incomplete one.
+func (s *AccountService) ExportRiderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is accepted")
+		return
+	}
+
+	riderID, err := authn.SubjectFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		respondWithError(w, http.StatusBadRequest, "Invalid format", "format must be json or zip")
+		return
+	}
+
+	detailsResp, err := s.auth.authClient.GetRiderDetails(r.Context(), &pb.GetRiderDetailsRequest{Id: riderID})
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Failed to export profile", err.Error())
+		return
+	}
+	if !detailsResp.Success {
+		respondWithError(w, int(detailsResp.Status), detailsResp.Message, detailsResp.Message)
+		return
+	}
+
+	paymentResp, err := s.paymentClient.ListCheckoutSessions(r.Context(), &pb.ListCheckoutSessionsRequest{RiderId: riderID})
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Failed to export payments", err.Error())
+		return
+	}
+	if !paymentResp.Success {
+		detail := "payment service error"
+		if paymentResp.Error != nil {
+			detail = paymentResp.Error.Message
+		}
+		respondWithError(w, http.StatusBadGateway, "Failed to export payments", detail)
+		return
+	}
+
+	sessionList, err := s.auth.sessions.List(riderID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to export sessions", err.Error())
+		return
+	}
+
+	enrollment, found, err := s.auth.mfa.Get(riderID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to export security settings", err.Error())
+		return
+	}
+
+	export := models.RiderExport{
+		ExportedAt:      time.Now().Unix(),
+		Profile:         userFromProto(detailsResp.User),
+		Sessions:        sessionsToModels(sessionList, middleware.GetSessionIDFromContext(r.Context())),
+		PaymentSessions: make([]models.PaymentSession, 0, len(paymentResp.Sessions)),
+	}
+	for _, p := range paymentResp.Sessions {
+		export.PaymentSessions = append(export.PaymentSessions, models.PaymentSession{
+			SessionID:       p.SessionId,
+			PaymentIntentID: p.PaymentIntentId,
+			Status:          p.Status,
+			PickupLocation:  p.PickupLocation,
+			DropoffLocation: p.DropoffLocation,
+			EstimatedPrice:  p.EstimatedPrice,
+			CreatedAt:       p.CreatedAt,
+		})
+	}
+	if found && enrollment.Confirmed {
+		export.Security = models.SecuritySettings{
+			MFAEnabled:           true,
+			MFAConfirmedAt:       enrollment.ConfirmedAt.Unix(),
+			BackupCodesRemaining: len(enrollment.BackupCodes),
+		}
+	}
+
+	// Personal data must not end up in shared caches
+	w.Header().Set("Cache-Control", "no-store")
+	filename := fmt.Sprintf("loop-export-%s", time.Now().UTC().Format("20060102"))
+
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+		if err := writeExportZip(w, export); err != nil {
+			log.Printf("failed to write data export: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+	respondWithJSON(w, http.StatusOK, export)
+}
+
+// writeExportZip writes one JSON file per section of the export
+func writeExportZip(w io.Writer, export models.RiderExport) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"sessions.json", export.Sessions},
+		{"payment_sessions.json", export.PaymentSessions},
+		{"security.json", export.Security},
+		{"export.json", map[string]int64{"exported_at": export.ExportedAt}},
+	}
+
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// DeleteRiderHandler schedules the logged in rider's account for deletion after the
+// grace period. The rider must re-enter their password, and a current code when
+// two-factor authentication is on. Every session is revoked immediately, and an
+// email with a cancellation link is sent.
+func (s *AccountService) DeleteRiderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only DELETE method is accepted")
+		return
+	}
+
+	riderID, err := authn.SubjectFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return
+	}
+	email, _ := middleware.GetEmailFromContext(r.Context())
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.DeleteRiderRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	if req.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "password is required to delete your account")
+		return
+	}
+
+	clientIP := middleware.GetClientIP(r)
+	if !s.auth.reserveLoginAttempt(w, email, clientIP) {
+		return
+	}
+
+	mfaEnabled, err := mfa.Enabled(s.auth.mfa, riderID)
+	if err != nil {
+		s.auth.releaseLoginAttempt(email, clientIP)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete account", err.Error())
+		return
+	}
+	if mfaEnabled && req.Code == "" {
+		s.auth.releaseLoginAttempt(email, clientIP)
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "code from your authenticator is required to delete your account")
+		return
+	}
+
+	// The password is checked before the code is spent, so a wrong password never
+	// burns a backup code
+	if !s.auth.verifyPassword(w, r, riderID, req.Password, email, clientIP) {
+		return
+	}
+
+	if mfaEnabled {
+		verified, _, err := mfa.Spend(s.auth.mfa, riderID, req.Code, time.Now())
+		if err != nil && !errors.Is(err, mfa.ErrNotEnabled) {
+			s.auth.releaseLoginAttempt(email, clientIP)
+			respondWithError(w, http.StatusInternalServerError, "Failed to delete account", err.Error())
+			return
+		}
+		// A wrong code keeps the reserved attempt as a failure. ErrNotEnabled means
+		// two-factor authentication was turned off meanwhile, so no code is needed
+		if err == nil && !verified {
+			respondWithError(w, http.StatusUnauthorized, "Invalid code", "Enter a code from your authenticator or a backup code")
+			return
+		}
+	}
+
+	deleteAt := time.Now().Add(s.deletionGrace)
+
+	grpcReq := &pb.ScheduleRiderDeletionRequest{
+		UserId:   riderID,
+		Password: req.Password,
+		DeleteAt: deleteAt.Unix(),
+	}
+
+	grpcResp, err := s.auth.authClient.ScheduleRiderDeletion(r.Context(), grpcReq)
+	if err != nil {
+		s.auth.releaseLoginAttempt(email, clientIP)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete account", err.Error())
+		return
+	}
+
+	if !grpcResp.Success {
+		if grpcResp.Status != http.StatusUnauthorized {
+			s.auth.releaseLoginAttempt(email, clientIP)
+		}
+		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+		return
+	}
+
+	if err := s.auth.loginGuard.Succeed(email, clientIP); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	if err := s.auth.revokeAllSessions(riderID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Account scheduled for deletion, but failed to sign out all devices", err.Error())
+		return
+	}
+	if err := s.auth.revokePresentedTokens(r); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Account scheduled for deletion, but failed to sign out this device", err.Error())
+		return
+	}
+	clearAuthCookies(w)
+
+	if err := s.sendCancellationEmail(riderID, email, deleteAt); err != nil {
+		log.Printf("failed to send deletion cancellation link: %v", err)
+	}
+
+	respondWithJSON(w, http.StatusAccepted, models.DeleteRiderResponse{
+		Success:  true,
+		Message:  "Your account will be deleted. Use the link we emailed you to cancel before then",
+		Status:   http.StatusAccepted,
+		DeleteAt: deleteAt.Unix(),
+	})
+}
+
+// CancelDeletionHandler restores an account scheduled for deletion using the emailed
+// link. The link works once; the rider then logs in again as usual.
+func (s *AccountService) CancelDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.CancelDeletionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	if req.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "token is required")
+		return
+	}
+
+	claims, err := jwtlib.VerifyCancelDeletionToken(req.Token, s.auth.keyring, jwtlib.CheckRevocation(s.auth.revocations))
+	if err != nil {
+		message := "Invalid cancellation link"
+		if errors.Is(err, jwtlib.ErrExpired) || errors.Is(err, jwtlib.ErrTokenRevoked) {
+			message = "Cancellation link has expired or was already used"
+		}
+		respondWithError(w, http.StatusBadRequest, message, jwtlib.ErrorCode(err))
+		return
+	}
+
+	// Accepted only over the gateway's client certificate; the rider's sessions were
+	// revoked when the deletion was scheduled, so there is no token to forward
+	grpcReq := &pb.CancelRiderDeletionRequest{
+		UserId: claims.UserID,
+	}
+
+	grpcResp, err := s.auth.authClient.CancelRiderDeletion(r.Context(), grpcReq)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to cancel deletion", err.Error())
+		return
+	}
+
+	if !grpcResp.Success {
+		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+		return
+	}
+
+	if err := revokeClaims(s.auth.revocations, claims); err != nil {
+		log.Printf("failed to revoke cancellation link: %v", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, models.MessageResponse{
+		Success: true,
+		Message: "Account deletion cancelled, you can login again",
+		Status:  http.StatusOK,
+	})
+}
+
+func (s *AccountService) sendCancellationEmail(userID string, email string, deleteAt time.Time) error {
+	cancelToken, err := jwtlib.GenerateCancelDeletionToken(email, userID, s.auth.keyring, time.Until(deleteAt))
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := s.auth.appBaseURL + "/cancel-deletion?token=" + url.QueryEscape(cancelToken)
+		msg := notify.Message{
+			Channel: notify.ChannelEmail,
+			To:      email,
+			Subject: "Your Loop account is scheduled for deletion",
+			Body: fmt.Sprintf("Your account and its data will be permanently deleted on %s. "+
+				"If you did not ask for this, or changed your mind, open the link below before then.\n\n%s",
+				deleteAt.UTC().Format("January 2, 2006"), link),
+		}
+		if err := s.auth.notifier.Send(context.Background(), msg); err != nil {
+			log.Printf("failed to send deletion email: %v", err)
+		}
+	}()
+
+	return nil
+}
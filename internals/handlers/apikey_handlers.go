@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/loop/backend/rider-auth/rest/internals/apikeys"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+)
+
+const (
+	defaultAPIKeyLifetimeDays = 90
+	maxAPIKeyLifetimeDays     = 365
+)
+
+// APIKeyService lets admins manage the API keys partners use instead of a rider login
+type APIKeyService struct {
+	store apikeys.Store
+}
+
+func NewAPIKeyService(store apikeys.Store) *APIKeyService {
+	return &APIKeyService{
+		store: store,
+	}
+}
+
+// CreateAPIKeyHandler issues a key. The plaintext is returned once and never stored.
+func (k *APIKeyService) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.CreateAPIKeyRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	req.Owner = strings.TrimSpace(req.Owner)
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Owner == "" || req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "owner and name are required")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "At least one scope is required")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apikeys.KnownScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, "Invalid scope", "Unknown scope "+scope+"; allowed: "+strings.Join(apikeys.KnownScopes, ", "))
+			return
+		}
+	}
+
+	riderIDs := make([]string, 0, len(req.RiderIDs))
+	for _, riderID := range req.RiderIDs {
+		riderID = strings.TrimSpace(riderID)
+		if riderID == "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid rider_ids", "rider_ids may not contain empty IDs")
+			return
+		}
+		riderIDs = append(riderIDs, riderID)
+	}
+	if slices.Contains(req.Scopes, apikeys.ScopeCheckoutCreate) && len(riderIDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "rider_ids is required with the "+apikeys.ScopeCheckoutCreate+" scope")
+		return
+	}
+
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPIKeyLifetimeDays
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyLifetimeDays {
+		respondWithError(w, http.StatusBadRequest, "Invalid expires_in_days", "Must be between 1 and 365")
+		return
+	}
+	expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+
+	plaintext, key, err := apikeys.Generate(req.Owner, req.Name, slices.Compact(slices.Sorted(slices.Values(req.Scopes))), slices.Compact(slices.Sorted(slices.Values(riderIDs))), expiresAt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create API key", err.Error())
+		return
+	}
+
+	if err := k.store.Create(key); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create API key", err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, models.CreateAPIKeyResponse{
+		Success: true,
+		Message: "API key created; store it now, it will not be shown again",
+		Status:  http.StatusCreated,
+		Key:     plaintext,
+		APIKey:  apiKeyToModel(key),
+	})
+}
+
+// ListAPIKeysHandler lists keys, optionally only those of ?owner=
+func (k *APIKeyService) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is accepted")
+		return
+	}
+
+	keys, err := k.store.List(r.URL.Query().Get("owner"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to list API keys", err.Error())
+		return
+	}
+
+	list := make([]models.APIKey, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, apiKeyToModel(key))
+	}
+
+	respondWithJSON(w, http.StatusOK, models.APIKeysResponse{
+		Success: true,
+		Message: "API keys retrieved successfully",
+		Status:  http.StatusOK,
+		Keys:    list,
+	})
+}
+
+// RevokeAPIKeyHandler revokes a key; requests using it are rejected from then on
+func (k *APIKeyService) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only DELETE method is accepted")
+		return
+	}
+
+	if err := k.store.Revoke(r.PathValue("id"), time.Now()); err != nil {
+		if errors.Is(err, apikeys.ErrInvalidKey) {
+			respondWithError(w, http.StatusNotFound, "API key not found", "No API key with this ID exists")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key", err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, models.MessageResponse{
+		Success: true,
+		Message: "API key revoked",
+		Status:  http.StatusOK,
+	})
+}
+
+func apiKeyToModel(key apikeys.Key) models.APIKey {
+	m := models.APIKey{
+		ID:        key.ID,
+		Owner:     key.Owner,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		RiderIDs:  key.RiderIDs,
+		CreatedAt: key.CreatedAt.Unix(),
+		ExpiresAt: key.ExpiresAt.Unix(),
+	}
+	if key.LastUsedAt != nil {
+		m.LastUsedAt = key.LastUsedAt.Unix()
+	}
+	if key.RevokedAt != nil {
+		m.RevokedAt = key.RevokedAt.Unix()
+	}
+	return m
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	pb "ravigill/rider-grpc-server/proto"
+)
+
+const (
+	accessTokenCookie  = "access_token"
+	refreshTokenCookie = "refresh_token"
+
+	accessTokenTTL  = 24 * time.Hour * 3
+	refreshTokenTTL = 24 * time.Hour * 7
+)
+
+// setAuthCookies writes the access and refresh token cookies for a freshly issued token pair
+func setAuthCookies(w http.ResponseWriter, token *pb.Token) {
+	access_cookie := http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    token.TokenType + " " + token.AccessToken,
+		Expires:  time.Now().Add(accessTokenTTL),
+		MaxAge:   int(accessTokenTTL.Seconds()), // 3 days in seconds
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	refresh_cookie := http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    token.TokenType + " " + token.RefreshToken,
+		Expires:  time.Now().Add(refreshTokenTTL),
+		MaxAge:   int(refreshTokenTTL.Seconds()), // 7 days in seconds
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	http.SetCookie(w, &access_cookie)
+	http.SetCookie(w, &refresh_cookie)
+}
+
+// clearAuthCookies expires the access and refresh token cookies
+func clearAuthCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    "",
+		Expires:  time.Now().Add(-accessTokenTTL),
+		MaxAge:   -int(accessTokenTTL.Seconds()),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    "",
+		Expires:  time.Now().Add(-refreshTokenTTL),
+		MaxAge:   -int(refreshTokenTTL.Seconds()),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// bearerToken strips the "Bearer" prefix stored alongside tokens in cookies and headers
+func bearerToken(value string) string {
+	return strings.TrimSpace(strings.TrimPrefix(value, "Bearer"))
+}
+
+// accessTokenFromRequest returns the access token as presented, "Bearer <token>", from
+// the Authorization header or the access_token cookie
+func accessTokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		return header
+	}
+	if cookie, err := r.Cookie(accessTokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
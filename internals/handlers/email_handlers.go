@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	pb "ravigill/rider-grpc-server/proto"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+	"github.com/loop/backend/rider-auth/rest/internals/notify"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+// sendVerificationEmail mints a verification token and mails the link in the background
+func (a *AuthService) sendVerificationEmail(userID string, email string) error {
+	verifyToken, err := jwtlib.GenerateEmailVerificationToken(email, userID, a.keyring, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := a.appBaseURL + "/verify-email?token=" + url.QueryEscape(verifyToken)
+		msg := notify.Message{
+			Channel: notify.ChannelEmail,
+			To:      email,
+			Subject: "Verify your Loop email address",
+			Body:    "Confirm this is your email address by opening the link below. It expires in 24 hours.\n\n" + link,
+		}
+		if err := a.notifier.Send(context.Background(), msg); err != nil {
+			log.Printf("failed to send verification email: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// VerifyEmailHandler marks the address in a verification link as verified
+func (a *AuthService) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is accepted")
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing token", "token query parameter is required")
+		return
+	}
+
+	claims, err := jwtlib.VerifyEmailVerificationToken(token, a.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification link", jwtlib.ErrorCode(err))
+		return
+	}
+
+	// Accepted only over the gateway's client certificate, since the rider comes
+	// from the link rather than a rider token
+	grpcReq := &pb.VerifyEmailRequest{
+		UserId: claims.UserID,
+		Email:  claims.Email,
+	}
+
+	grpcResp, err := a.authClient.VerifyEmail(r.Context(), grpcReq)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify email", err.Error())
+		return
+	}
+
+	if !grpcResp.Success {
+		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+		return
+	}
+
+	// Existing access tokens still say email_verified=false until the next refresh
+	respondWithJSON(w, int(grpcResp.Status), models.MessageResponse{
+		Success: grpcResp.Success,
+		Message: grpcResp.Message,
+		Status:  grpcResp.Status,
+	})
+}
+
+// ResendVerificationEmailHandler mails a fresh verification link to the logged in rider
+func (a *AuthService) ResendVerificationEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	riderID, err := authn.SubjectFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return
+	}
+
+	email, err := middleware.GetEmailFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return
+	}
+
+	if middleware.IsEmailVerified(r.Context()) {
+		respondWithJSON(w, http.StatusOK, models.MessageResponse{
+			Success: true,
+			Message: "Email address is already verified",
+			Status:  http.StatusOK,
+		})
+		return
+	}
+
+	if err := a.sendVerificationEmail(riderID, email); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to send verification email", err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, models.MessageResponse{
+		Success: true,
+		Message: "Verification email sent",
+		Status:  http.StatusAccepted,
+	})
+}
+
+// sendEmailChangeEmail mails the link that confirms a new address to that address
+func (a *AuthService) sendEmailChangeEmail(userID string, newEmail string) error {
+	changeToken, err := jwtlib.GenerateEmailChangeToken(newEmail, userID, a.keyring, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		link := a.appBaseURL + "/confirm-email-change?token=" + url.QueryEscape(changeToken)
+		msg := notify.Message{
+			Channel: notify.ChannelEmail,
+			To:      newEmail,
+			Subject: "Confirm your new Loop email address",
+			Body:    "Open the link below to use this address for your Loop account. Until then your current address stays in place. It expires in 24 hours.\n\n" + link,
+		}
+		if err := a.notifier.Send(context.Background(), msg); err != nil {
+			log.Printf("failed to send email change link: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// ConfirmEmailChangeHandler replaces the rider's email with the address in a link sent by
+// UpdateRiderHandler. Opening the link proves the rider reads that inbox, so the address
+// is marked verified, and the old address is told about the change.
+func (a *AuthService) ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is accepted")
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing token", "token query parameter is required")
+		return
+	}
+
+	claims, err := jwtlib.VerifyEmailChangeToken(token, a.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired confirmation link", jwtlib.ErrorCode(err))
+		return
+	}
+
+	// Single use, so an old link cannot switch the address back after a later change
+	if err := jwtlib.Redeem(a.revocations, claims); err != nil {
+		if errors.Is(err, jwtlib.ErrTokenRevoked) || errors.Is(err, jwtlib.ErrMissingClaim) {
+			respondWithError(w, http.StatusBadRequest, "Confirmation link has expired or was already used", jwtlib.ErrorCode(err))
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to change email", err.Error())
+		return
+	}
+
+	// There is no rider token on this route, so the auth service only takes the call
+	// over the gateway's client certificate. It replaces the address and marks it
+	// verified in one step.
+	grpcResp, err := a.authClient.ConfirmEmailChange(r.Context(), &pb.ConfirmEmailChangeRequest{
+		UserId: claims.UserID,
+		Email:  claims.Email,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to change email", err.Error())
+		return
+	}
+	if !grpcResp.Success {
+		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+		return
+	}
+	oldEmail := grpcResp.PreviousEmail
+
+	if oldEmail != "" && !strings.EqualFold(oldEmail, claims.Email) {
+		go func() {
+			msg := notify.Message{
+				Channel: notify.ChannelEmail,
+				To:      oldEmail,
+				Subject: "Your Loop email address was changed",
+				Body:    "Your Loop account now uses " + claims.Email + ". If you did not make this change, reset your password and contact support.",
+			}
+			if err := a.notifier.Send(context.Background(), msg); err != nil {
+				log.Printf("failed to send email change notice: %v", err)
+			}
+		}()
+	}
+
+	// Existing access tokens still carry the old address until the next refresh
+	respondWithJSON(w, http.StatusOK, models.UpdateRiderResponse{
+		Success: true,
+		Message: "Email address changed",
+		Status:  http.StatusOK,
+		User:    userFromProto(grpcResp.User),
+	})
+}
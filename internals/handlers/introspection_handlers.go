@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+)
+
+// DefaultIntrospectionCacheTTL is how long callers may cache an introspection result.
+// A token revoked meanwhile can be reported active for up to this long.
+const DefaultIntrospectionCacheTTL = 30 * time.Second
+
+// IntrospectionService lets services that cannot verify our tokens themselves ask
+// whether a rider's access token is active (RFC 7662)
+type IntrospectionService struct {
+	keys       jwtlib.KeySource
+	verifyOpts []jwtlib.VerifyOption
+	cacheTTL   time.Duration
+}
+
+// NewIntrospectionService checks tokens with the same revocation store and options as
+// the auth middleware
+func NewIntrospectionService(keys jwtlib.KeySource, revocations jwtlib.RevocationStore, cacheTTL time.Duration, opts ...jwtlib.VerifyOption) *IntrospectionService {
+	return &IntrospectionService{
+		keys:       keys,
+		verifyOpts: append([]jwtlib.VerifyOption{jwtlib.CheckRevocation(revocations)}, opts...),
+		cacheTTL:   cacheTTL,
+	}
+}
+
+// IntrospectHandler takes a form-encoded token parameter. Any token that fails
+// verification, including refresh, partner and other non-access tokens, is reported
+// as inactive rather than as an error. Malformed requests get an RFC 6749 error body.
+//
+// The Cache-Control header only tells the calling service how long it may keep the
+// answer: shared caches do not store responses to POST, so callers that want the
+// cache must key it on the token themselves.
+func (i *IntrospectionService) IntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		respondWithOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "Only POST method is accepted")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	token := bearerToken(r.PostForm.Get("token"))
+	if token == "" {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	claims, err := jwtlib.VerifyAccessToken(token, i.keys, i.verifyOpts...)
+	if err != nil {
+		i.setCacheControl(w, i.cacheTTL)
+		respondWithJSON(w, http.StatusOK, models.IntrospectionResponse{Active: false})
+		return
+	}
+
+	resp := models.IntrospectionResponse{
+		Active:        true,
+		Scope:         claims.Scope,
+		TokenType:     "Bearer",
+		Sub:           claims.UserID,
+		Aud:           claims.Audience,
+		Iss:           claims.Issuer,
+		Jti:           claims.ID,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		SessionID:     claims.SessionID,
+		Roles:         claims.Roles,
+	}
+
+	// Never let a cached answer outlive the token itself
+	ttl := i.cacheTTL
+	if claims.ExpiresAt != nil {
+		resp.Exp = claims.ExpiresAt.Unix()
+		ttl = min(ttl, time.Until(claims.ExpiresAt.Time))
+	}
+	if claims.IssuedAt != nil {
+		resp.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		resp.Nbf = claims.NotBefore.Unix()
+	}
+
+	i.setCacheControl(w, ttl)
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (i *IntrospectionService) setCacheControl(w http.ResponseWriter, ttl time.Duration) {
+	seconds := int(ttl.Seconds())
+	if seconds <= 0 {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", seconds))
+}
+
+// respondWithOAuthError writes the {"error": ...} body RFC 7662 clients expect
+func respondWithOAuthError(w http.ResponseWriter, statusCode int, code string, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, statusCode, models.OAuthErrorResponse{Error: code, ErrorDescription: description})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+)
+
+func TestIntrospectHandler(t *testing.T) {
+	keyring, err := jwtlib.NewSingleKeyring("test-secret-test-secret-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := jwtlib.GenerateAccessToken("rider@example.com", "rider-1", keyring, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshToken, err := jwtlib.GenerateRefreshToken("rider@example.com", "rider-1", keyring, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partnerToken, err := jwtlib.GeneratePartnerToken("key-1", keyring, time.Minute, jwtlib.WithScopes("checkout:create"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		form       url.Values
+		wantStatus int
+		// want holds the fields the JSON body must contain
+		want map[string]any
+	}{
+		{
+			name:       "access token",
+			method:     http.MethodPost,
+			form:       url.Values{"token": {accessToken}},
+			wantStatus: http.StatusOK,
+			want:       map[string]any{"active": true, "sub": "rider-1"},
+		},
+		{
+			name:       "refresh token",
+			method:     http.MethodPost,
+			form:       url.Values{"token": {refreshToken}},
+			wantStatus: http.StatusOK,
+			want:       map[string]any{"active": false},
+		},
+		{
+			name:       "partner token",
+			method:     http.MethodPost,
+			form:       url.Values{"token": {partnerToken}},
+			wantStatus: http.StatusOK,
+			want:       map[string]any{"active": false},
+		},
+		{
+			name:       "missing token",
+			method:     http.MethodPost,
+			form:       url.Values{},
+			wantStatus: http.StatusBadRequest,
+			want:       map[string]any{"error": "invalid_request"},
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+			want:       map[string]any{"error": "invalid_request"},
+		},
+	}
+
+	service := NewIntrospectionService(keyring, jwtlib.NewMemoryRevocationStore(), DefaultIntrospectionCacheTTL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/auth/introspect", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+
+			service.IntrospectHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", rec.Body.String(), err)
+			}
+			for field, want := range tt.want {
+				if body[field] != want {
+					t.Errorf("%s = %v, want %v", field, body[field], want)
+				}
+			}
+			if active, _ := body["active"].(bool); !active {
+				if _, ok := body["sub"]; ok {
+					t.Errorf("inactive response carries sub %v", body["sub"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	pb "ravigill/rider-grpc-server/proto"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/lib/mfa"
+	"github.com/loop/backend/rider-auth/lib/totp"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	totpIssuer = "Loop"
+	qrCodeSize = 256
+)
+
+// MFAEnrollHandler starts TOTP enrollment for the logged in rider. The secret stays
+// pending, and login keeps working without a code, until MFAConfirmHandler succeeds.
+func (a *AuthService) MFAEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	riderID, err := authn.SubjectFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return
+	}
+	email, _ := middleware.GetEmailFromContext(r.Context())
+
+	enabled, err := mfa.Enabled(a.mfa, riderID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to start enrollment", err.Error())
+		return
+	}
+	if enabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", "Disable it before enrolling a new authenticator")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to start enrollment", err.Error())
+		return
+	}
+
+	uri := totp.URI(secret, totpIssuer, email)
+	png, err := qrcode.Encode(uri, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to start enrollment", err.Error())
+		return
+	}
+
+	if err := a.mfa.Put(riderID, mfa.Enrollment{Secret: secret}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to start enrollment", err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, models.MFAEnrollResponse{
+		Success:    true,
+		Message:    "Scan the QR code with your authenticator app, then confirm with a code",
+		Status:     http.StatusOK,
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRCodePNG:  base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// MFAConfirmHandler turns on two-factor authentication once the rider proves their
+// authenticator works, and returns the first set of backup codes
+func (a *AuthService) MFAConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	riderID, req, ok := a.readMFACodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := mfa.NewBackupCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to confirm enrollment", err.Error())
+		return
+	}
+
+	// Checked and confirmed in one update so the code cannot be replayed
+	var alreadyEnabled, verified bool
+	found, err := a.mfa.Update(riderID, func(enrollment *mfa.Enrollment) {
+		if alreadyEnabled = enrollment.Confirmed; alreadyEnabled {
+			return
+		}
+		if verified = enrollment.VerifyTOTP(req.Code, time.Now()); verified {
+			enrollment.Confirmed = true
+			enrollment.ConfirmedAt = time.Now()
+			enrollment.BackupCodes = hashes
+		}
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to confirm enrollment", err.Error())
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusBadRequest, "No enrollment in progress", "Call POST /api/auth/mfa/enroll first")
+		return
+	}
+	if alreadyEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", "Nothing to confirm")
+		return
+	}
+	if !verified {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", "The code did not match your authenticator")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, models.MFABackupCodesResponse{
+		Success:     true,
+		Message:     "Two-factor authentication enabled. Store these backup codes somewhere safe",
+		Status:      http.StatusOK,
+		BackupCodes: codes,
+	})
+}
+
+// MFADisableHandler turns two-factor authentication off after checking the current
+// password and a current code. Both count towards the login lockout.
+func (a *AuthService) MFADisableHandler(w http.ResponseWriter, r *http.Request) {
+	riderID, req, ok := a.readMFACodeRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.CurrentPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "current_password is required")
+		return
+	}
+
+	email, _ := middleware.GetEmailFromContext(r.Context())
+	clientIP := middleware.GetClientIP(r)
+	if !a.reserveLoginAttempt(w, email, clientIP) {
+		return
+	}
+	if !a.verifyPassword(w, r, riderID, req.CurrentPassword, email, clientIP) {
+		return
+	}
+
+	verified, _, err := mfa.Spend(a.mfa, riderID, req.Code, time.Now())
+	if err != nil {
+		a.releaseLoginAttempt(email, clientIP)
+		respondWithMFAStoreError(w, "Failed to disable two-factor authentication", err)
+		return
+	}
+	// A wrong code keeps the reserved attempt as a failure
+	if !verified {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", "Enter a code from your authenticator or a backup code")
+		return
+	}
+
+	if err := a.loginGuard.Succeed(email, clientIP); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	if err := a.mfa.Delete(riderID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication", err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, models.MessageResponse{
+		Success: true,
+		Message: "Two-factor authentication disabled",
+		Status:  http.StatusOK,
+	})
+}
+
+// MFABackupCodesHandler replaces the rider's backup codes after checking a current code.
+// The code counts towards the login lockout.
+func (a *AuthService) MFABackupCodesHandler(w http.ResponseWriter, r *http.Request) {
+	riderID, req, ok := a.readMFACodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := mfa.NewBackupCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate backup codes", err.Error())
+		return
+	}
+
+	email, _ := middleware.GetEmailFromContext(r.Context())
+	clientIP := middleware.GetClientIP(r)
+	if !a.reserveLoginAttempt(w, email, clientIP) {
+		return
+	}
+
+	var enabled, verified bool
+	found, err := a.mfa.Update(riderID, func(enrollment *mfa.Enrollment) {
+		if enabled = enrollment.Confirmed; !enabled {
+			return
+		}
+		if verified = enrollment.VerifyTOTP(req.Code, time.Now()); verified {
+			enrollment.BackupCodes = hashes
+		}
+	})
+	if err == nil && (!found || !enabled) {
+		err = mfa.ErrNotEnabled
+	}
+	if err != nil {
+		a.releaseLoginAttempt(email, clientIP)
+		respondWithMFAStoreError(w, "Failed to generate backup codes", err)
+		return
+	}
+	// A wrong code keeps the reserved attempt as a failure
+	if !verified {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", "The code did not match your authenticator")
+		return
+	}
+
+	if err := a.loginGuard.Succeed(email, clientIP); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, models.MFABackupCodesResponse{
+		Success:     true,
+		Message:     "New backup codes generated, the previous ones no longer work",
+		Status:      http.StatusOK,
+		BackupCodes: codes,
+	})
+}
+
+// MFAVerifyHandler finishes a login that LoginHandler answered with mfa_required
+func (a *AuthService) MFAVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.MFAVerifyRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	if req.ChallengeToken == "" || req.Code == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "challenge_token and code are required")
+		return
+	}
+
+	claims, err := jwtlib.VerifyMFAChallengeToken(req.ChallengeToken, a.keyring, jwtlib.CheckRevocation(a.revocations))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Login challenge expired, please login again", jwtlib.ErrorCode(err))
+		return
+	}
+
+	// A wrong code counts as a failed login for the email and IP, so the password
+	// cannot be used to get unlimited guesses at the second factor
+	clientIP := middleware.GetClientIP(r)
+	if !a.reserveLoginAttempt(w, claims.Email, clientIP) {
+		return
+	}
+
+	verified, failures, err := mfa.Spend(a.mfa, claims.UserID, req.Code, time.Now())
+	if errors.Is(err, mfa.ErrNotEnabled) {
+		a.releaseLoginAttempt(claims.Email, clientIP)
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication is not enabled", "Please login again")
+		return
+	}
+	if err != nil {
+		a.releaseLoginAttempt(claims.Email, clientIP)
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify code", err.Error())
+		return
+	}
+
+	if !verified {
+		if failures >= mfa.MaxFailedAttempts {
+			// Burn the challenge so further guesses need the password again
+			if err := jwtlib.Redeem(a.revocations, claims); err != nil && !errors.Is(err, jwtlib.ErrTokenRevoked) {
+				respondWithError(w, http.StatusInternalServerError, "Failed to verify code", err.Error())
+				return
+			}
+		}
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", "Enter a code from your authenticator or a backup code")
+		return
+	}
+
+	// Redeeming fails for all but one of several requests racing with the same
+	// challenge and different valid codes
+	if err := jwtlib.Redeem(a.revocations, claims); err != nil {
+		a.releaseLoginAttempt(claims.Email, clientIP)
+		if errors.Is(err, jwtlib.ErrTokenRevoked) || errors.Is(err, jwtlib.ErrMissingClaim) {
+			respondWithError(w, http.StatusUnauthorized, "Login challenge expired, please login again", jwtlib.ErrorCode(err))
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify code", err.Error())
+		return
+	}
+
+	if err := a.loginGuard.Succeed(claims.Email, clientIP); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	session, err := newSession(r, req.DeviceName)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify code", err.Error())
+		return
+	}
+
+	// Accepted only over the gateway's client certificate: the challenge and code,
+	// not a credential in the call, prove the rider
+	grpcReq := &pb.IssueTokensRequest{
+		UserId:    claims.UserID,
+		SessionId: session.ID,
+	}
+
+	grpcResp, err := a.authClient.IssueTokens(r.Context(), grpcReq)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to login", err.Error())
+		return
+	}
+
+	if !grpcResp.Success || grpcResp.Token == nil {
+		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+		return
+	}
+
+	resp := models.LoginResponse{
+		Success: grpcResp.Success,
+		Message: grpcResp.Message,
+		Status:  grpcResp.Status,
+		User:    userFromProto(grpcResp.User),
+	}
+
+	saveSession(a.sessions, session, claims.UserID)
+	setAuthCookies(w, grpcResp.Token)
+
+	respondWithJSON(w, int(grpcResp.Status), resp)
+}
+
+// respondWithMFAChallenge passes on the challenge token the auth service returned in
+// place of tokens for a rider with two-factor authentication on
+func respondWithMFAChallenge(w http.ResponseWriter, challenge string) {
+	clearAuthCookies(w)
+	respondWithJSON(w, http.StatusOK, models.MFAChallengeResponse{
+		Success:        true,
+		Message:        "mfa_required",
+		Status:         http.StatusOK,
+		MFARequired:    true,
+		ChallengeToken: challenge,
+	})
+}
+
+// readMFACodeRequest handles the method check, rider lookup and body parsing shared by
+// the authenticated MFA endpoints
+func (a *AuthService) readMFACodeRequest(w http.ResponseWriter, r *http.Request) (string, models.MFACodeRequest, bool) {
+	var req models.MFACodeRequest
+
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return "", req, false
+	}
+
+	riderID, err := authn.SubjectFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return "", req, false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return "", req, false
+	}
+	defer r.Body.Close()
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return "", req, false
+	}
+
+	if req.Code == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "code is required")
+		return "", req, false
+	}
+
+	return riderID, req, true
+}
+
+// respondWithMFAStoreError answers a failed mfa.Spend or Store.Update
+func respondWithMFAStoreError(w http.ResponseWriter, message string, err error) {
+	if errors.Is(err, mfa.ErrNotEnabled) {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication is not enabled", "Nothing to change")
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, message, err.Error())
+}
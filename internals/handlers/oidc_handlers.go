@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	pb "ravigill/rider-grpc-server/proto"
+
+	"github.com/loop/backend/rider-auth/rest/internals/oidc"
+)
+
+const (
+	oidcStateCookie    = "oidc_state"
+	oidcNonceCookie    = "oidc_nonce"
+	oidcVerifierCookie = "oidc_pkce"
+	oidcCookiePath     = "/api/auth/oidc/"
+	oidcFlowTTL        = 10 * time.Minute
+)
+
+// OIDCService signs riders in with external OpenID providers such as Google and Apple.
+// It reuses the AuthService's keyring and MFA store so social logins get the same
+// second factor as password logins.
+type OIDCService struct {
+	auth      *AuthService
+	providers map[string]*oidc.Provider
+}
+
+func NewOIDCService(auth *AuthService, providers map[string]*oidc.Provider) *OIDCService {
+	return &OIDCService{
+		auth:      auth,
+		providers: providers,
+	}
+}
+
+// StartOIDCHandler redirects the browser to the provider's sign-in page. State, nonce
+// and the PKCE verifier are kept in short-lived cookies scoped to the OIDC routes.
+func (o *OIDCService) StartOIDCHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is accepted")
+		return
+	}
+
+	provider, ok := o.providers[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown sign-in provider", r.PathValue("provider"))
+		return
+	}
+
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to start sign-in", err.Error())
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), flow)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Sign-in provider unavailable", err.Error())
+		return
+	}
+
+	setOIDCCookie(w, oidcStateCookie, flow.State, oidcFlowTTL)
+	setOIDCCookie(w, oidcNonceCookie, flow.Nonce, oidcFlowTTL)
+	setOIDCCookie(w, oidcVerifierCookie, flow.CodeVerifier, oidcFlowTTL)
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler finishes the sign-in. Providers redirect here with a GET, or a
+// form POST when response_mode=form_post (Apple). The browser always ends up back in
+// the app: on success with session cookies, or at /login/mfa with a challenge token
+// when the rider has two-factor authentication, or at /login?error=... otherwise.
+func (o *OIDCService) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET and POST methods are accepted")
+		return
+	}
+
+	providerName := r.PathValue("provider")
+	provider, ok := o.providers[providerName]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown sign-in provider", providerName)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		o.redirectWithError(w, r, "invalid_request")
+		return
+	}
+
+	flow, ok := readOIDCFlow(r)
+	clearOIDCCookies(w)
+
+	if providerErr := r.FormValue("error"); providerErr != "" {
+		o.redirectWithError(w, r, "access_denied")
+		return
+	}
+
+	state := r.FormValue("state")
+	if !ok || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(flow.State)) != 1 {
+		o.redirectWithError(w, r, "invalid_state")
+		return
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		o.redirectWithError(w, r, "invalid_request")
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), code, flow)
+	if err != nil {
+		log.Printf("oidc: %s sign-in failed: %v", providerName, err)
+		o.redirectWithError(w, r, "invalid_id_token")
+		return
+	}
+
+	session, err := newSession(r, "")
+	if err != nil {
+		o.redirectWithError(w, r, "server_error")
+		return
+	}
+
+	// Accepted only over the gateway's client certificate, since the gateway is what
+	// verified the provider's ID token
+	grpcReq := &pb.LoginWithOIDCRequest{
+		Provider:      providerName,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		FullName:      claims.Name,
+		SessionId:     session.ID,
+	}
+
+	grpcResp, err := o.auth.authClient.LoginWithOIDC(r.Context(), grpcReq)
+	if err != nil {
+		log.Printf("oidc: LoginWithOIDC failed: %v", err)
+		o.redirectWithError(w, r, "server_error")
+		return
+	}
+
+	if grpcResp.Success && grpcResp.MfaRequired {
+		clearAuthCookies(w)
+		// The fragment keeps the challenge out of server logs and Referer headers
+		http.Redirect(w, r, o.auth.appBaseURL+"/login/mfa#challenge_token="+url.QueryEscape(grpcResp.MfaChallenge), http.StatusSeeOther)
+		return
+	}
+
+	if !grpcResp.Success || grpcResp.User == nil || grpcResp.Token == nil {
+		o.redirectWithError(w, r, "login_failed")
+		return
+	}
+
+	saveSession(o.auth.sessions, session, grpcResp.User.Id)
+	setAuthCookies(w, grpcResp.Token)
+	http.Redirect(w, r, o.auth.appBaseURL+"/", http.StatusSeeOther)
+}
+
+func (o *OIDCService) redirectWithError(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, o.auth.appBaseURL+"/login?error="+url.QueryEscape(code), http.StatusSeeOther)
+}
+
+func readOIDCFlow(r *http.Request) (oidc.Flow, bool) {
+	var flow oidc.Flow
+	for name, field := range map[string]*string{
+		oidcStateCookie:    &flow.State,
+		oidcNonceCookie:    &flow.Nonce,
+		oidcVerifierCookie: &flow.CodeVerifier,
+	} {
+		cookie, err := r.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return oidc.Flow{}, false
+		}
+		*field = cookie.Value
+	}
+	return flow, true
+}
+
+// setOIDCCookie uses SameSite=None because form_post callbacks arrive as a cross-site POST
+func setOIDCCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Expires:  time.Now().Add(ttl),
+		MaxAge:   int(ttl.Seconds()),
+		Path:     oidcCookiePath,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
+
+func clearOIDCCookies(w http.ResponseWriter) {
+	for _, name := range []string{oidcStateCookie, oidcNonceCookie, oidcVerifierCookie} {
+		setOIDCCookie(w, name, "", -time.Hour)
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	pb "ravigill/rider-grpc-server/proto"
+
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+	"github.com/loop/backend/rider-auth/rest/internals/notify"
+	"github.com/loop/backend/rider-auth/rest/internals/otp"
+)
+
+// e164Pattern accepts phone numbers in E.164 form, e.g. +14155550123
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+// OTPService logs riders in with a code texted to their phone. It reuses the
+// AuthService's client, OTP manager, MFA store and sessions, so a phone login still
+// asks for the rider's second factor and codes it sends can also confirm a new phone
+// number in UpdateRiderHandler.
+type OTPService struct {
+	auth     *AuthService
+	notifier notify.Notifier
+}
+
+func NewOTPService(auth *AuthService, notifier notify.Notifier) *OTPService {
+	return &OTPService{
+		auth:     auth,
+		notifier: notifier,
+	}
+}
+
+// StartOTPHandler texts a one-time login code to a phone number. Like forgot-password it
+// answers the same way for registered and unknown numbers. Codes are limited per number
+// and per client IP.
+func (o *OTPService) StartOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.StartOTPRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	phone := strings.TrimSpace(req.PhoneNumber)
+	if !e164Pattern.MatchString(phone) {
+		respondWithError(w, http.StatusBadRequest, "Invalid phone_number", "phone_number must be in E.164 format, e.g. +14155550123")
+		return
+	}
+
+	code, err := o.auth.otp.Start(phone, middleware.GetClientIP(r))
+	if err != nil {
+		var rateErr *otp.RateLimitError
+		if errors.As(err, &rateErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateErr.RetryAfter.Seconds()))))
+			respondWithError(w, http.StatusTooManyRequests, "Too many codes requested", rateErr.Error())
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to send code", err.Error())
+		return
+	}
+
+	go func() {
+		msg := notify.Message{
+			Channel: notify.ChannelSMS,
+			To:      phone,
+			Body:    fmt.Sprintf("Your Loop login code is %s. It expires in %d minutes.", code, int(o.auth.otp.CodeTTL().Minutes())),
+		}
+		if err := o.notifier.Send(context.Background(), msg); err != nil {
+			log.Printf("failed to send login code: %v", err)
+		}
+	}()
+
+	respondWithJSON(w, http.StatusAccepted, models.MessageResponse{
+		Success: true,
+		Message: "If this number can receive SMS, a login code has been sent",
+		Status:  http.StatusAccepted,
+	})
+}
+
+// VerifyOTPHandler checks a login code and, on success, logs the rider in with the same
+// cookies as LoginHandler, or answers mfa_required like LoginHandler when the rider has
+// two-factor authentication enabled
+func (o *OTPService) VerifyOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.VerifyOTPRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	phone := strings.TrimSpace(req.PhoneNumber)
+	if phone == "" || req.Code == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "phone_number and code are required")
+		return
+	}
+
+	if err := o.auth.otp.Verify(phone, strings.TrimSpace(req.Code)); err != nil {
+		respondWithOTPError(w, err)
+		return
+	}
+
+	session, err := newSession(r, req.DeviceName)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to login", err.Error())
+		return
+	}
+
+	// Accepted only over the gateway's client certificate: the texted code, not a
+	// credential in the call, proves the number
+	grpcReq := &pb.LoginWithPhoneRequest{
+		PhoneNumber: phone,
+		SessionId:   session.ID,
+	}
+
+	grpcResp, err := o.auth.authClient.LoginWithPhone(r.Context(), grpcReq)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to login", err.Error())
+		return
+	}
+
+	if !grpcResp.Success {
+		clearAuthCookies(w)
+		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+		return
+	}
+
+	// The texted code replaces the password, not the second factor
+	if grpcResp.MfaRequired {
+		respondWithMFAChallenge(w, grpcResp.MfaChallenge)
+		return
+	}
+
+	if grpcResp.User == nil || grpcResp.Token == nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to login", "login response has no user or token")
+		return
+	}
+
+	resp := models.LoginResponse{
+		Success: grpcResp.Success,
+		Message: grpcResp.Message,
+		Status:  grpcResp.Status,
+		User:    userFromProto(grpcResp.User),
+	}
+
+	saveSession(o.auth.sessions, session, grpcResp.User.Id)
+	setAuthCookies(w, grpcResp.Token)
+
+	respondWithJSON(w, int(grpcResp.Status), resp)
+}
+
+// respondWithOTPError answers a failed otp.Manager.Verify
+func respondWithOTPError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, otp.ErrInvalidCode):
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", err.Error())
+	case errors.Is(err, otp.ErrExpired), errors.Is(err, otp.ErrNoChallenge), errors.Is(err, otp.ErrTooManyAttempts):
+		respondWithError(w, http.StatusUnauthorized, "Code is no longer valid, request a new one", err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify code", err.Error())
+	}
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "ravigill/rider-grpc-server/proto"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/rest/internals/lockout"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+	"github.com/loop/backend/rider-auth/rest/internals/notify"
+)
+
+const passwordResetTTL = 15 * time.Minute
+
+// ForgotPasswordHandler sends a password reset link to the given email. It answers the
+// same way whether or not the email is registered so the endpoint cannot be used to
+// discover accounts. Requests are limited per email and per client IP through
+// resetGuard, so the endpoint cannot be used to flood an inbox.
+func (a *AuthService) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.ForgotPasswordRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "email is required")
+		return
+	}
+
+	// Each request is reserved and never marked as a success, so it counts until the
+	// window runs out
+	if err := a.resetGuard.Reserve(email, middleware.GetClientIP(r), time.Now()); err != nil {
+		var lockedErr *lockout.LockedError
+		if errors.As(err, &lockedErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedErr.RetryAfter.Seconds()))))
+			respondWithError(w, http.StatusTooManyRequests, "Too many password reset requests", "Please wait before trying again")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to start password reset", err.Error())
+		return
+	}
+
+	resetToken, err := jwtlib.GeneratePasswordResetToken(email, a.keyring, passwordResetTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to start password reset", err.Error())
+		return
+	}
+
+	// Sent in the background so response time does not depend on the notifier
+	go func() {
+		link := a.appBaseURL + "/reset-password?token=" + url.QueryEscape(resetToken)
+		msg := notify.Message{
+			Channel: notify.ChannelEmail,
+			To:      email,
+			Subject: "Reset your Loop password",
+			Body: "Use the link below to choose a new password. It expires in 15 minutes.\n\n" + link +
+				"\n\nIf you did not ask to reset your password you can ignore this email.",
+		}
+		if err := a.notifier.Send(context.Background(), msg); err != nil {
+			log.Printf("failed to send password reset email: %v", err)
+		}
+	}()
+
+	respondWithJSON(w, http.StatusAccepted, models.MessageResponse{
+		Success: true,
+		Message: "If an account exists for this email, a password reset link has been sent",
+		Status:  http.StatusAccepted,
+	})
+}
+
+// ResetPasswordHandler redeems a reset token and forwards the new password to the auth
+// service. The token is redeemed atomically before the call so it can only be used
+// once, even by concurrent requests, and released again if the auth service could not
+// be reached. Every session of the rider is then signed out.
+func (a *AuthService) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.ResetPasswordRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	if req.Token == "" || req.NewPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "token and new_password are required")
+		return
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid new_password", err.Error())
+		return
+	}
+
+	claims, err := jwtlib.VerifyPasswordResetToken(req.Token, a.keyring, jwtlib.CheckRevocation(a.revocations))
+	if err != nil {
+		message := "Invalid reset token"
+		if errors.Is(err, jwtlib.ErrExpired) || errors.Is(err, jwtlib.ErrTokenRevoked) {
+			message = "Reset link has expired or was already used"
+		}
+		respondWithError(w, http.StatusBadRequest, message, jwtlib.ErrorCode(err))
+		return
+	}
+
+	if err := jwtlib.Redeem(a.revocations, claims); err != nil {
+		if errors.Is(err, jwtlib.ErrTokenRevoked) {
+			respondWithError(w, http.StatusBadRequest, "Reset link has expired or was already used", jwtlib.ErrorCode(err))
+			return
+		}
+		if errors.Is(err, jwtlib.ErrMissingClaim) {
+			respondWithError(w, http.StatusBadRequest, "Invalid reset token", jwtlib.ErrorCode(err))
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset password", err.Error())
+		return
+	}
+
+	// The auth service only takes this call over the gateway's client certificate,
+	// since the email comes from the request rather than a rider token
+	grpcReq := &pb.ResetPasswordRequest{
+		Email:       claims.Email,
+		NewPassword: req.NewPassword,
+	}
+
+	grpcResp, err := a.authClient.ResetPassword(r.Context(), grpcReq)
+	if err != nil {
+		// The password was not changed, so the rider can retry with the same link
+		if err := jwtlib.Release(a.revocations, claims); err != nil {
+			log.Printf("Failed to release password reset token: %v", err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset password", err.Error())
+		return
+	}
+
+	if !grpcResp.Success {
+		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+		return
+	}
+
+	// Whoever may have known the old password is signed out too
+	if grpcResp.UserId == "" {
+		respondWithError(w, http.StatusInternalServerError, "Password reset, but failed to sign out other devices", "auth service did not return the rider ID")
+		return
+	}
+	if err := a.revokeAllSessions(grpcResp.UserId); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Password reset, but failed to sign out other devices", err.Error())
+		return
+	}
+
+	respondWithJSON(w, int(grpcResp.Status), models.MessageResponse{
+		Success: grpcResp.Success,
+		Message: grpcResp.Message,
+		Status:  grpcResp.Status,
+	})
+}
+
+// ChangePasswordHandler changes the logged in rider's password. Every session of the
+// rider is signed out, including the current one, which is replaced by a fresh session
+// with new cookies so the device making the change stays logged in.
+func (a *AuthService) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	riderID, err := authn.SubjectFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return
+	}
+	email, _ := middleware.GetEmailFromContext(r.Context())
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.ChangePasswordRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "current_password and new_password are required")
+		return
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Password does not meet requirements", err.Error())
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		respondWithError(w, http.StatusBadRequest, "Password does not meet requirements", "new_password must differ from current_password")
+		return
+	}
+
+	// A stolen access token must not become a way to guess the password
+	clientIP := middleware.GetClientIP(r)
+	if !a.reserveLoginAttempt(w, email, clientIP) {
+		return
+	}
+
+	grpcReq := &pb.ChangePasswordRequest{
+		UserId:          riderID,
+		CurrentPassword: req.CurrentPassword,
+		NewPassword:     req.NewPassword,
+	}
+
+	grpcResp, err := a.authClient.ChangePassword(r.Context(), grpcReq)
+	if err != nil {
+		a.releaseLoginAttempt(email, clientIP)
+		respondWithError(w, http.StatusInternalServerError, "Failed to change password", err.Error())
+		return
+	}
+
+	// Only a wrong current password keeps its reserved attempt as a failure
+	if !grpcResp.Success {
+		if grpcResp.Status != http.StatusUnauthorized {
+			a.releaseLoginAttempt(email, clientIP)
+		}
+		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+		return
+	}
+
+	if err := a.loginGuard.Succeed(email, clientIP); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	// The password is changed at this point, so sign-out failures are reported as a
+	// server error for the client to retry rather than hidden
+	if err := a.revokeAllSessions(riderID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Password changed, but failed to sign out other devices", err.Error())
+		return
+	}
+	if err := a.revokePresentedTokens(r); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Password changed, but failed to sign out this device", err.Error())
+		return
+	}
+
+	deviceName := ""
+	if current, found, err := a.sessions.Get(middleware.GetSessionIDFromContext(r.Context())); err == nil && found {
+		deviceName = current.DeviceName
+	}
+
+	session, err := newSession(r, deviceName)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to change password", err.Error())
+		return
+	}
+
+	// IssueTokens is a gateway-only RPC, so this goes over the client certificate even
+	// though the rider is signed in
+	issueResp, err := a.authClient.IssueTokens(r.Context(), &pb.IssueTokensRequest{
+		UserId:    riderID,
+		SessionId: session.ID,
+	})
+	if err != nil || !issueResp.Success || issueResp.Token == nil {
+		clearAuthCookies(w)
+		respondWithJSON(w, http.StatusOK, models.ChangePasswordResponse{
+			Success: true,
+			Message: "Password changed, please login again",
+			Status:  http.StatusOK,
+		})
+		return
+	}
+
+	saveSession(a.sessions, session, riderID)
+	setAuthCookies(w, issueResp.Token)
+
+	resp := models.ChangePasswordResponse{
+		Success: true,
+		Message: "Password changed, other devices have been signed out",
+		Status:  http.StatusOK,
+	}
+
+	if r.Header.Get("Authorization") != "" {
+		resp.Token = &models.Tokens{
+			AccessToken:  issueResp.Token.AccessToken,
+			RefreshToken: issueResp.Token.RefreshToken,
+			TokenType:    issueResp.Token.TokenType,
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -1,25 +1,27 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"io"
 	"net/http"
+	"strings"
 
 	pb "ravigill/rider-grpc-server/proto"
 
-	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+	"github.com/loop/backend/rider-auth/lib/authn"
+	"github.com/loop/backend/rider-auth/rest/internals/apikeys"
 	"github.com/loop/backend/rider-auth/rest/internals/models"
-	"google.golang.org/grpc/metadata"
 )
 
 type PaymentService struct {
 	paymentClient pb.PaymentServiceClient
+	apiKeys       apikeys.Store
 }
 
-func NewPaymentService(paymentClient pb.PaymentServiceClient) *PaymentService {
+func NewPaymentService(paymentClient pb.PaymentServiceClient, apiKeyStore apikeys.Store) *PaymentService {
 	return &PaymentService{
 		paymentClient: paymentClient,
+		apiKeys:       apiKeyStore,
 	}
 }
 
@@ -29,21 +31,9 @@ func (p *PaymentService) CreateCheckoutSessionHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		cookie, err := r.Cookie("access_token")
-		if err == nil {
-			authHeader = cookie.Value
-		}
-	}
-
-	rider_id, ok := r.Context().Value(middleware.RiderIDKey).(string)
+	principal, ok := authn.PrincipalFromContext(r.Context())
 	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
-	}
-
-	if authHeader == "" {
-		respondWithError(w, http.StatusUnauthorized, "Missing authorization token", "Authorization header or cookie is required")
 		return
 	}
 
@@ -65,8 +55,10 @@ func (p *PaymentService) CreateCheckoutSessionHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	ctx := context.Background()
-	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authHeader)
+	rider_id, ok := p.checkoutRider(w, principal, strings.TrimSpace(req.RiderID))
+	if !ok {
+		return
+	}
 
 	grpcReq := &pb.CreateCheckOutSessionRequest{
 		RiderId:              rider_id,
@@ -88,7 +80,7 @@ func (p *PaymentService) CreateCheckoutSessionHandler(w http.ResponseWriter, r *
 		},
 	}
 
-	grpcResp, err := p.paymentClient.CreateCheckOutSession(ctx, grpcReq)
+	grpcResp, err := p.paymentClient.CreateCheckOutSession(r.Context(), grpcReq)
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to create checkout session", err.Error())
@@ -118,3 +110,36 @@ func (p *PaymentService) CreateCheckoutSessionHandler(w http.ResponseWriter, r *
 
 	respondWithJSON(w, statusCode, resp)
 }
+
+// checkoutRider returns the rider a checkout is billed to. Riders book for themselves.
+// Partners must name the rider, who has to be on their API key's rider list; the key's
+// owner is never billed.
+func (p *PaymentService) checkoutRider(w http.ResponseWriter, principal *authn.Principal, requested string) (string, bool) {
+	if principal.ClientID == "" {
+		if principal.Subject == "" {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+			return "", false
+		}
+		if requested != "" && requested != principal.Subject {
+			respondWithError(w, http.StatusForbidden, "Forbidden", "Riders can only create checkout sessions for themselves")
+			return "", false
+		}
+		return principal.Subject, true
+	}
+
+	if requested == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "rider_id is required when using an API key")
+		return "", false
+	}
+
+	key, found, err := p.apiKeys.Get(principal.ClientID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to check API key", err.Error())
+		return "", false
+	}
+	if !found || !key.CanActFor(requested) {
+		respondWithError(w, http.StatusForbidden, "Forbidden", "This API key may not create checkout sessions for rider "+requested)
+		return "", false
+	}
+	return requested, true
+}
@@ -1,26 +1,55 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	pb "ravigill/rider-grpc-server/proto"
 
+	"github.com/loop/backend/rider-auth/lib/authn"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/lib/mfa"
+	"github.com/loop/backend/rider-auth/rest/internals/lockout"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
 	"github.com/loop/backend/rider-auth/rest/internals/models"
-	"google.golang.org/grpc/metadata"
+	"github.com/loop/backend/rider-auth/rest/internals/notify"
+	"github.com/loop/backend/rider-auth/rest/internals/otp"
+	"github.com/loop/backend/rider-auth/rest/internals/sessions"
 )
 
 type AuthService struct {
-	authClient pb.AuthServiceClient
+	authClient  pb.AuthServiceClient
+	keyring     *jwtlib.Keyring
+	revocations jwtlib.RevocationStore
+	notifier    notify.Notifier
+	appBaseURL  string
+	mfa         mfa.Store
+	loginGuard  *lockout.Guard
+	resetGuard  *lockout.Guard
+	sessions    sessions.Store
+	otp         *otp.Manager
 }
 
-func NewAuthService(authClient pb.AuthServiceClient) *AuthService {
+func NewAuthService(authClient pb.AuthServiceClient, keyring *jwtlib.Keyring, revocations jwtlib.RevocationStore, notifier notify.Notifier, appBaseURL string, mfaStore mfa.Store, loginGuard *lockout.Guard, resetGuard *lockout.Guard, sessionStore sessions.Store, otpManager *otp.Manager) *AuthService {
 
 	return &AuthService{
-		authClient: authClient,
+		authClient:  authClient,
+		keyring:     keyring,
+		revocations: revocations,
+		notifier:    notifier,
+		appBaseURL:  strings.TrimSuffix(appBaseURL, "/"),
+		mfa:         mfaStore,
+		loginGuard:  loginGuard,
+		resetGuard:  resetGuard,
+		sessions:    sessionStore,
+		otp:         otpManager,
 	}
 }
 
@@ -52,6 +81,12 @@ func (a *AuthService) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	session, err := newSession(r, req.DeviceName)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to register user", err.Error())
+		return
+	}
+
 	// Call gRPC service
 	grpcReq := &pb.RegisterRequest{
 		User: &pb.User{
@@ -62,9 +97,10 @@ func (a *AuthService) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 			BirthMonth:  req.BirthMonth,
 			BirthYear:   req.BirthYear,
 		},
+		SessionId: session.ID,
 	}
 
-	grpcResp, err := a.authClient.Register(context.Background(), grpcReq)
+	grpcResp, err := a.authClient.Register(r.Context(), grpcReq)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to register user", err.Error())
 		return
@@ -83,42 +119,18 @@ func (a *AuthService) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if grpcResp.User != nil {
-		resp.User = &models.User{
-			ID:          grpcResp.User.Id,
-			Email:       grpcResp.User.Email,
-			FullName:    grpcResp.User.FullName,
-			PhoneNumber: grpcResp.User.PhoneNumber,
-			BirthMonth:  grpcResp.User.BirthMonth,
-			BirthYear:   grpcResp.User.BirthYear,
-			UpdatedAt:   grpcResp.User.UpdatedAt,
-			CreatedAt:   grpcResp.User.CreatedAt,
-		}
-	}
-
-	access_cookie := http.Cookie{
-		Name:     "access_token",
-		Value:    grpcResp.Token.TokenType + " " + grpcResp.Token.AccessToken,
-		Expires:  time.Now().Add(24 * time.Hour * 3),
-		MaxAge:   60 * 60 * 24 * 3,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-	}
-
-	refresh_cookie := http.Cookie{
-		Name:     "refresh_token",
-		Value:    grpcResp.Token.TokenType + " " + grpcResp.Token.RefreshToken,
-		Expires:  time.Now().Add(24 * time.Hour * 7),
-		MaxAge:   60 * 60 * 24 * 7,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-	}
-
-	http.SetCookie(w, &access_cookie)
-	http.SetCookie(w, &refresh_cookie)
+		resp.User = userFromProto(grpcResp.User)
+	}
+
+	if grpcResp.User != nil {
+		// The account exists either way; a failed send can be retried via resend
+		if err := a.sendVerificationEmail(grpcResp.User.Id, grpcResp.User.Email); err != nil {
+			log.Printf("failed to start email verification: %v", err)
+		}
+		saveSession(a.sessions, session, grpcResp.User.Id)
+	}
+
+	setAuthCookies(w, grpcResp.Token)
 
 	respondWithJSON(w, int(grpcResp.Status), resp)
 }
@@ -147,42 +159,53 @@ func (a *AuthService) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	clientIP := middleware.GetClientIP(r)
+	if !a.reserveLoginAttempt(w, req.Email, clientIP) {
+		return
+	}
+
+	session, err := newSession(r, req.DeviceName)
+	if err != nil {
+		a.releaseLoginAttempt(req.Email, clientIP)
+		respondWithError(w, http.StatusInternalServerError, "Failed to login", err.Error())
+		return
+	}
+
 	grpcReq := &pb.LoginRequest{
-		Email:    req.Email,
-		Password: req.Password,
+		Email:     req.Email,
+		Password:  req.Password,
+		SessionId: session.ID,
 	}
 
-	grpcResp, err := a.authClient.Login(context.Background(), grpcReq)
+	grpcResp, err := a.authClient.Login(r.Context(), grpcReq)
 	if err != nil {
+		a.releaseLoginAttempt(req.Email, clientIP)
 		respondWithError(w, http.StatusInternalServerError, "Failed to login", err.Error())
 		return
 	}
 
+	// A rejected password keeps its reserved attempt as a failure
 	if !grpcResp.Success {
-		http.SetCookie(w, &http.Cookie{
-			Name:     "access_token",
-			Value:    "",
-			Expires:  time.Now().Add(-time.Hour * 24 * 3),
-			MaxAge:   -60 * 60 * 24 * 3,
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteStrictMode,
-		})
-		http.SetCookie(w, &http.Cookie{
-			Name:     "refresh_token",
-			Value:    "",
-			Expires:  time.Now().Add(-time.Hour * 24 * 7),
-			MaxAge:   -60 * 60 * 24 * 7,
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteStrictMode,
-		})
+		if grpcResp.Status >= http.StatusInternalServerError {
+			a.releaseLoginAttempt(req.Email, clientIP)
+		}
+		clearAuthCookies(w)
 		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
 		return
 	}
 
+	// The earlier failures stay counted until the second factor passes too;
+	// MFAVerifyHandler reserves an attempt of its own for the code
+	if grpcResp.MfaRequired {
+		a.releaseLoginAttempt(req.Email, clientIP)
+		respondWithMFAChallenge(w, grpcResp.MfaChallenge)
+		return
+	}
+
+	if err := a.loginGuard.Succeed(req.Email, clientIP); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
 	resp := models.LoginResponse{
 		Success: grpcResp.Success,
 		Message: grpcResp.Message,
@@ -190,78 +213,203 @@ func (a *AuthService) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if grpcResp.User != nil {
-		resp.User = &models.User{
-			ID:          grpcResp.User.Id,
-			Email:       grpcResp.User.Email,
-			FullName:    grpcResp.User.FullName,
-			PhoneNumber: grpcResp.User.PhoneNumber,
-			BirthMonth:  grpcResp.User.BirthMonth,
-			BirthYear:   grpcResp.User.BirthYear,
-			UpdatedAt:   grpcResp.User.UpdatedAt,
-			CreatedAt:   grpcResp.User.CreatedAt,
-		}
-	}
-
-	access_cookie := http.Cookie{
-		Name:     "access_token",
-		Value:    grpcResp.Token.TokenType + " " + grpcResp.Token.AccessToken,
-		Expires:  time.Now().Add(24 * time.Hour * 3),
-		MaxAge:   60 * 60 * 24 * 3, // 3 days in seconds
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-	}
-
-	refresh_cookie := http.Cookie{
-		Name:     "refresh_token",
-		Value:    grpcResp.Token.TokenType + " " + grpcResp.Token.RefreshToken,
-		Expires:  time.Now().Add(24 * time.Hour * 7),
-		MaxAge:   60 * 60 * 24 * 7, // 7 days in seconds
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-	}
-
-	http.SetCookie(w, &access_cookie)
-	http.SetCookie(w, &refresh_cookie)
+		resp.User = userFromProto(grpcResp.User)
+		saveSession(a.sessions, session, grpcResp.User.Id)
+	}
+
+	setAuthCookies(w, grpcResp.Token)
 
 	respondWithJSON(w, int(grpcResp.Status), resp)
 }
 
-func (a *AuthService) GetRiderDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	// Validate HTTP method
-	if r.Method != http.MethodGet {
-		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is accepted")
+// RefreshTokenHandler exchanges a refresh token for a new access/refresh token pair.
+// The refresh token is read from the refresh_token cookie, falling back to the JSON body.
+func (a *AuthService) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.RefreshTokenRequest
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+			return
+		}
+	}
+
+	// Clients that send the token in the body (mobile) get the new pair back in the body too
+	fromBody := req.RefreshToken != ""
+
+	refreshToken := bearerToken(req.RefreshToken)
+	if refreshToken == "" {
+		if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+			refreshToken = bearerToken(cookie.Value)
+		}
+	}
+
+	if refreshToken == "" {
+		respondWithError(w, http.StatusUnauthorized, "Missing refresh token", "refresh_token cookie or body field is required")
+		return
+	}
+
+	// Refresh tokens are verified by the auth service; the gateway only rejects tokens of
+	// the wrong type and those whose ID or session is on the revocation list
+	var sessionID string
+	if claims, err := jwtlib.PeekClaims(refreshToken); err == nil {
+		sessionID = claims.SessionID
+		if claims.TokenType != jwtlib.TokenTypeRefresh {
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", "Only refresh tokens can be exchanged")
+			return
+		}
+		if err := jwtlib.CheckRevoked(a.revocations, claims); err != nil {
+			clearAuthCookies(w)
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err.Error())
+			return
+		}
+	}
+
+	grpcReq := &pb.RefreshTokenRequest{
+		RefreshToken: refreshToken,
+	}
+
+	grpcResp, err := a.authClient.RefreshToken(r.Context(), grpcReq)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to refresh token", err.Error())
+		return
+	}
+
+	if !grpcResp.Success || grpcResp.Token == nil {
+		clearAuthCookies(w)
+		respondWithError(w, http.StatusUnauthorized, grpcResp.Message, "Refresh token is invalid or expired, please login again")
 		return
 	}
 
-	// Extract token from Authorization header or Cookie
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		// Try to get from cookie
-		cookie, err := r.Cookie("access_token")
-		if err == nil {
-			authHeader = cookie.Value
+	if sessionID != "" {
+		if err := a.sessions.Touch(sessionID, time.Now()); err != nil {
+			log.Printf("Failed to record session activity: %v", err)
+		}
+	}
+
+	resp := models.RefreshTokenResponse{
+		Success: grpcResp.Success,
+		Message: grpcResp.Message,
+		Status:  grpcResp.Status,
+	}
+
+	if fromBody {
+		resp.Token = &models.Tokens{
+			AccessToken:  grpcResp.Token.AccessToken,
+			RefreshToken: grpcResp.Token.RefreshToken,
+			TokenType:    grpcResp.Token.TokenType,
 		}
 	}
 
-	if authHeader == "" {
-		respondWithError(w, http.StatusUnauthorized, "Missing authorization token", "Authorization header or cookie is required")
+	setAuthCookies(w, grpcResp.Token)
+
+	respondWithJSON(w, int(grpcResp.Status), resp)
+}
+
+// LogoutHandler ends the current session: both cookies are cleared, and the IDs of the
+// presented access and refresh tokens and of their session are revoked until they
+// would have expired.
+func (a *AuthService) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST method is accepted")
 		return
 	}
 
-	// Create context with authorization metadata for gRPC
-	ctx := context.Background()
-	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authHeader)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.RefreshTokenRequest
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+			return
+		}
+	}
+
+	accessToken := r.Header.Get("Authorization")
+	if accessToken == "" {
+		if cookie, err := r.Cookie(accessTokenCookie); err == nil {
+			accessToken = cookie.Value
+		}
+	}
+
+	refreshToken := req.RefreshToken
+	if refreshToken == "" {
+		if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+			refreshToken = cookie.Value
+		}
+	}
+
+	// An invalid or already expired access token needs no revocation, logout still succeeds
+	var sessionID string
+	if token := bearerToken(accessToken); token != "" {
+		if claims, err := jwtlib.VerifyAccessToken(token, a.keyring); err == nil {
+			sessionID = claims.SessionID
+			if err := revokeClaims(a.revocations, claims); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Failed to logout", err.Error())
+				return
+			}
+		}
+	}
+
+	// Only a verified refresh token is recorded. A forged one could otherwise add entries
+	// with any expiry to the revocation list, or end someone else's session via its sid.
+	if token := bearerToken(refreshToken); token != "" {
+		if claims, err := jwtlib.VerifyRefreshToken(token, a.keyring); err == nil {
+			if err := revokeClaims(a.revocations, claims); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Failed to logout", err.Error())
+				return
+			}
+			if sessionID == "" {
+				sessionID = claims.SessionID
+			}
+		}
+	}
+
+	if sessionID != "" {
+		if err := a.revokeSession(sessionID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to logout", err.Error())
+			return
+		}
+	}
+
+	clearAuthCookies(w)
+
+	respondWithJSON(w, http.StatusOK, models.LogoutResponse{
+		Success: true,
+		Message: "Logged out successfully",
+		Status:  http.StatusOK,
+	})
+}
+
+func (a *AuthService) GetRiderDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	// Validate HTTP method
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is accepted")
+		return
+	}
 
 	// Call gRPC service (ID will come from the token context)
 	grpcReq := &pb.GetRiderDetailsRequest{
 		Id: "", // Not used anymore, comes from auth context
 	}
 
-	grpcResp, err := a.authClient.GetRiderDetails(ctx, grpcReq)
+	grpcResp, err := a.authClient.GetRiderDetails(r.Context(), grpcReq)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to get rider details", err.Error())
 		return
@@ -275,21 +423,308 @@ func (a *AuthService) GetRiderDetailsHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	if grpcResp.User != nil {
-		resp.User = &models.User{
-			ID:          grpcResp.User.Id,
-			Email:       grpcResp.User.Email,
-			FullName:    grpcResp.User.FullName,
-			PhoneNumber: grpcResp.User.PhoneNumber,
-			BirthMonth:  grpcResp.User.BirthMonth,
-			BirthYear:   grpcResp.User.BirthYear,
-			UpdatedAt:   grpcResp.User.UpdatedAt,
-			CreatedAt:   grpcResp.User.CreatedAt,
-		}
+		resp.User = userFromProto(grpcResp.User)
 	}
 
 	respondWithJSON(w, int(grpcResp.Status), resp)
 }
 
+// UpdateRiderHandler applies a partial profile update. The client sends the updated_at
+// it last read; if the profile changed since, the update is refused with 409 and the
+// current profile so that two devices never silently overwrite each other.
+//
+// Email and phone number are where reset links and login codes go, so changing them
+// needs the current password or a code texted to the current number. A new phone
+// number must be confirmed with a code texted to it, and a new email address only
+// replaces the old one once the link mailed to it is opened.
+func (a *AuthService) UpdateRiderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only PATCH method is accepted")
+		return
+	}
+
+	riderID, err := authn.SubjectFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read request body", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.UpdateRiderRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON payload", err.Error())
+		return
+	}
+
+	if req.UpdatedAt == 0 {
+		respondWithError(w, http.StatusBadRequest, "Missing required fields", "updated_at from the last read of the profile is required")
+		return
+	}
+
+	user := &pb.User{Id: riderID}
+	var mask []string
+	pendingEmail := ""
+
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if err := validateEmail(email); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid email", err.Error())
+			return
+		}
+		if claimsEmail, _ := middleware.GetEmailFromContext(r.Context()); !strings.EqualFold(claimsEmail, email) {
+			pendingEmail = email
+		}
+	}
+	if req.FullName != nil {
+		fullName := strings.TrimSpace(*req.FullName)
+		if err := validateFullName(fullName); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid full name", err.Error())
+			return
+		}
+		user.FullName = fullName
+		mask = append(mask, "full_name")
+	}
+	if req.PhoneNumber != nil {
+		phone := strings.TrimSpace(*req.PhoneNumber)
+		if err := validatePhoneNumber(phone); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid phone number", err.Error())
+			return
+		}
+		if phone != "" && req.PhoneCode == "" {
+			respondWithError(w, http.StatusBadRequest, "Missing required fields", "phone_code texted to the new number is required")
+			return
+		}
+		user.PhoneNumber = phone
+		mask = append(mask, "phone_number")
+	}
+	if req.BirthMonth != nil {
+		month := strings.TrimSpace(*req.BirthMonth)
+		if err := validateBirthMonth(month); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid birth month", err.Error())
+			return
+		}
+		user.BirthMonth = month
+		mask = append(mask, "birth_month")
+	}
+	if req.BirthYear != nil {
+		if err := validateBirthYear(*req.BirthYear); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid birth year", err.Error())
+			return
+		}
+		user.BirthYear = *req.BirthYear
+		mask = append(mask, "birth_year")
+	}
+
+	if len(mask) == 0 && pendingEmail == "" {
+		respondWithError(w, http.StatusBadRequest, "Nothing to update", "Send at least one of email, full_name, phone_number, birth_month, birth_year with a new value")
+		return
+	}
+
+	if pendingEmail != "" || req.PhoneNumber != nil {
+		if req.CurrentPassword == "" && req.CurrentPhoneCode == "" {
+			respondWithError(w, http.StatusBadRequest, "Missing required fields", "current_password or current_phone_code is required to change email or phone_number")
+			return
+		}
+		if !a.reauthenticate(w, r, riderID, req.CurrentPassword, req.CurrentPhoneCode) {
+			return
+		}
+	}
+	if user.PhoneNumber != "" {
+		if err := a.otp.Verify(user.PhoneNumber, strings.TrimSpace(req.PhoneCode)); err != nil {
+			respondWithOTPError(w, err)
+			return
+		}
+	}
+
+	resp := models.UpdateRiderResponse{
+		Success:      true,
+		Message:      "Profile updated",
+		Status:       http.StatusOK,
+		PendingEmail: pendingEmail,
+	}
+
+	if len(mask) > 0 {
+		// The auth service compares updated_at and writes in one step, a check here would race
+		grpcReq := &pb.UpdateRiderRequest{
+			User:              user,
+			UpdateMask:        mask,
+			ExpectedUpdatedAt: req.UpdatedAt,
+		}
+
+		grpcResp, err := a.authClient.UpdateRider(r.Context(), grpcReq)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to update rider", err.Error())
+			return
+		}
+
+		if grpcResp.Status == http.StatusConflict {
+			respondWithJSON(w, http.StatusConflict, models.UpdateRiderResponse{
+				Success: false,
+				Message: "Profile was changed on another device, reload it and try again",
+				Status:  http.StatusConflict,
+				User:    userFromProto(grpcResp.User),
+			})
+			return
+		}
+
+		if !grpcResp.Success {
+			respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+			return
+		}
+		resp.User = userFromProto(grpcResp.User)
+	} else {
+		resp.Message = "Open the link sent to the new email address to finish the change"
+		resp.Status = http.StatusAccepted
+	}
+
+	if pendingEmail != "" {
+		if err := a.sendEmailChangeEmail(riderID, pendingEmail); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to send the email confirmation link", err.Error())
+			return
+		}
+	}
+
+	respondWithJSON(w, int(resp.Status), resp)
+}
+
+// JWKSHandler publishes the public signing keys so other services can verify rider
+// tokens without holding a secret that could also mint them
+func (a *AuthService) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is accepted")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, a.keyring.JWKS())
+}
+
+// reserveLoginAttempt counts a password attempt before the password is checked. It
+// answers 429 with Retry-After, and returns false, while the email or IP is locked out
+// of password checks. Callers end the reservation with releaseLoginAttempt when the
+// password was never checked, and with loginGuard.Succeed when it was right. Codes
+// for the second factor are reserved the same way.
+func (a *AuthService) reserveLoginAttempt(w http.ResponseWriter, email string, clientIP string) bool {
+	err := a.loginGuard.Reserve(email, clientIP, time.Now())
+	if err == nil {
+		return true
+	}
+
+	var lockedErr *lockout.LockedError
+	if errors.As(err, &lockedErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedErr.RetryAfter.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, "Too many failed attempts", "Please wait before trying again")
+		return false
+	}
+
+	respondWithError(w, http.StatusInternalServerError, "Failed to check password attempts", err.Error())
+	return false
+}
+
+// releaseLoginAttempt gives back a reserved attempt whose password was never checked
+func (a *AuthService) releaseLoginAttempt(email string, clientIP string) {
+	if err := a.loginGuard.Release(email, clientIP); err != nil {
+		log.Printf("Failed to release login attempt: %v", err)
+	}
+}
+
+// verifyPassword asks the auth service to check the rider's password, after the caller
+// has reserved a login attempt. A wrong password keeps the attempt as a failure; any
+// other failure gives it back. It answers the request and returns false on failure.
+func (a *AuthService) verifyPassword(w http.ResponseWriter, r *http.Request, riderID string, password string, email string, clientIP string) bool {
+	grpcResp, err := a.authClient.VerifyPassword(r.Context(), &pb.VerifyPasswordRequest{
+		UserId:   riderID,
+		Password: password,
+	})
+	if err != nil {
+		a.releaseLoginAttempt(email, clientIP)
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify password", err.Error())
+		return false
+	}
+	if !grpcResp.Success {
+		if grpcResp.Status != http.StatusUnauthorized {
+			a.releaseLoginAttempt(email, clientIP)
+		}
+		respondWithError(w, int(grpcResp.Status), grpcResp.Message, grpcResp.Message)
+		return false
+	}
+	return true
+}
+
+// reauthenticate checks the current password, or a login code texted to the rider's
+// current phone number, before a change to the rider's contact details. It answers the
+// request and returns false when neither passes. Password guesses count towards the
+// login lockout, as in ChangePasswordHandler.
+func (a *AuthService) reauthenticate(w http.ResponseWriter, r *http.Request, riderID string, password string, phoneCode string) bool {
+	if password != "" {
+		email, _ := middleware.GetEmailFromContext(r.Context())
+		clientIP := middleware.GetClientIP(r)
+		if !a.reserveLoginAttempt(w, email, clientIP) {
+			return false
+		}
+
+		if !a.verifyPassword(w, r, riderID, password, email, clientIP) {
+			return false
+		}
+
+		if err := a.loginGuard.Succeed(email, clientIP); err != nil {
+			log.Printf("Failed to reset login failures: %v", err)
+		}
+		return true
+	}
+
+	details, err := a.authClient.GetRiderDetails(r.Context(), &pb.GetRiderDetailsRequest{Id: riderID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify code", err.Error())
+		return false
+	}
+	if !details.Success || details.User == nil {
+		respondWithError(w, int(details.Status), details.Message, details.Message)
+		return false
+	}
+	if details.User.PhoneNumber == "" {
+		respondWithError(w, http.StatusBadRequest, "No phone number on file", "Use current_password instead")
+		return false
+	}
+
+	if err := a.otp.Verify(details.User.PhoneNumber, strings.TrimSpace(phoneCode)); err != nil {
+		respondWithOTPError(w, err)
+		return false
+	}
+	return true
+}
+
+// revokeClaims records the token ID until the token's own expiry
+func revokeClaims(store jwtlib.RevocationStore, claims *jwtlib.CustomClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	return store.Revoke(claims.ID, claims.ExpiresAt.Time)
+}
+
+// userFromProto converts the gRPC user into its REST representation
+func userFromProto(u *pb.User) *models.User {
+	if u == nil {
+		return nil
+	}
+	return &models.User{
+		ID:          u.Id,
+		Email:       u.Email,
+		FullName:    u.FullName,
+		PhoneNumber: u.PhoneNumber,
+		BirthMonth:  u.BirthMonth,
+		BirthYear:   u.BirthYear,
+		UpdatedAt:   u.UpdatedAt,
+		CreatedAt:   u.CreatedAt,
+	}
+}
+
 func respondWithJSON(w http.ResponseWriter, statusCode int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+	"github.com/loop/backend/rider-auth/rest/internals/sessions"
+)
+
+const maxDeviceNameLength = 100
+
+// ListSessionsHandler lists the devices the rider is logged in on
+func (a *AuthService) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET method is accepted")
+		return
+	}
+
+	riderID, err := authn.SubjectFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return
+	}
+
+	list, err := a.sessions.List(riderID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to list sessions", err.Error())
+		return
+	}
+
+	currentID := middleware.GetSessionIDFromContext(r.Context())
+
+	respondWithJSON(w, http.StatusOK, models.SessionsResponse{
+		Success:  true,
+		Message:  "Sessions retrieved successfully",
+		Status:   http.StatusOK,
+		Sessions: sessionsToModels(list, currentID),
+	})
+}
+
+// RevokeSessionHandler signs one of the rider's devices out. Every token issued for
+// the session stops working immediately, including its refresh token.
+func (a *AuthService) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only DELETE method is accepted")
+		return
+	}
+
+	riderID, err := authn.SubjectFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", "Please login to perform this action.")
+		return
+	}
+
+	sessionID := r.PathValue("id")
+
+	session, found, err := a.sessions.Get(sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke session", err.Error())
+		return
+	}
+	if !found || session.UserID != riderID {
+		respondWithError(w, http.StatusNotFound, "Session not found", "No session with this ID is active on your account")
+		return
+	}
+
+	if err := a.revokeSession(sessionID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke session", err.Error())
+		return
+	}
+
+	if sessionID == middleware.GetSessionIDFromContext(r.Context()) {
+		clearAuthCookies(w)
+	}
+
+	respondWithJSON(w, http.StatusOK, models.MessageResponse{
+		Success: true,
+		Message: "Session signed out",
+		Status:  http.StatusOK,
+	})
+}
+
+func sessionsToModels(list []sessions.Session, currentID string) []models.Session {
+	out := make([]models.Session, 0, len(list))
+	for _, session := range list {
+		out = append(out, models.Session{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastSeenAt: session.LastSeenAt.Unix(),
+			Current:    session.ID == currentID,
+		})
+	}
+	return out
+}
+
+// revokeSession puts the session ID on the revocation list for as long as any of its
+// refresh tokens could still be valid, and forgets the session record
+func (a *AuthService) revokeSession(sessionID string) error {
+	if err := a.revocations.Revoke(sessionID, time.Now().Add(refreshTokenTTL)); err != nil {
+		return err
+	}
+	return a.sessions.Delete(sessionID)
+}
+
+// revokeAllSessions signs the rider out everywhere. The issued-before cutoff rejects
+// every token issued so far, including those of sessions this gateway has no record
+// of; the known sessions are also revoked and forgotten so they leave the session list.
+func (a *AuthService) revokeAllSessions(riderID string) error {
+	now := time.Now()
+	if err := a.revocations.RevokeIssuedBefore(riderID, now, now.Add(refreshTokenTTL)); err != nil {
+		return err
+	}
+
+	list, err := a.sessions.List(riderID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range list {
+		if err := a.revokeSession(session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokePresentedTokens revokes the request's own access token and refresh cookie,
+// which the issued-before cutoff misses when they were issued within its second
+func (a *AuthService) revokePresentedTokens(r *http.Request) error {
+	if claims, err := jwtlib.VerifyAccessToken(bearerToken(accessTokenFromRequest(r)), a.keyring); err == nil {
+		if err := revokeClaims(a.revocations, claims); err != nil {
+			return err
+		}
+	}
+	if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+		if claims, err := jwtlib.VerifyRefreshToken(bearerToken(cookie.Value), a.keyring); err == nil {
+			if err := revokeClaims(a.revocations, claims); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newSession prepares the record for a login about to be sent to the auth service,
+// which stamps the session ID into the tokens it issues as the sid claim
+func newSession(r *http.Request, deviceName string) (sessions.Session, error) {
+	id, err := jwtlib.NewTokenID()
+	if err != nil {
+		return sessions.Session{}, err
+	}
+
+	deviceName = strings.TrimSpace(deviceName)
+	if len(deviceName) > maxDeviceNameLength {
+		deviceName = deviceName[:maxDeviceNameLength]
+	}
+
+	now := time.Now()
+	return sessions.Session{
+		ID:         id,
+		DeviceName: deviceName,
+		UserAgent:  r.UserAgent(),
+		IP:         middleware.GetClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}, nil
+}
+
+// saveSession records the session once tokens carrying its ID reach the rider. The
+// tokens are already issued, so a failure here only hides the session from the list.
+func saveSession(store sessions.Store, session sessions.Session, userID string) {
+	session.UserID = userID
+	if err := store.Create(session); err != nil {
+		log.Printf("Failed to record session: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 128
+	maxFullNameLength = 100
+	maxEmailLength    = 254
+	minBirthYear      = 1900
+)
+
+// validatePassword enforces the rider password policy: 8 to 128 characters with at
+// least one letter and one digit
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be between %d and %d characters", minPasswordLength, maxPasswordLength)
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+
+	if !hasLetter || !hasDigit {
+		return fmt.Errorf("password must contain at least one letter and one digit")
+	}
+	return nil
+}
+
+// validateFullName requires 1 to 100 characters with no control characters
+func validateFullName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("full_name must not be empty")
+	}
+	if utf8.RuneCountInString(name) > maxFullNameLength {
+		return fmt.Errorf("full_name must be at most %d characters", maxFullNameLength)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("full_name must not contain control characters")
+		}
+	}
+	return nil
+}
+
+// validateEmail accepts a bare address such as rider@example.com
+func validateEmail(email string) error {
+	if len(email) > maxEmailLength {
+		return fmt.Errorf("email must be at most %d characters", maxEmailLength)
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("email must be a valid address")
+	}
+	return nil
+}
+
+// validatePhoneNumber accepts E.164 numbers, or empty to remove the number
+func validatePhoneNumber(phone string) error {
+	if phone != "" && !e164Pattern.MatchString(phone) {
+		return fmt.Errorf("phone_number must be in E.164 format, e.g. +14155550123")
+	}
+	return nil
+}
+
+// validateBirthMonth accepts a month number (1 to 12, optionally zero padded) or an
+// English month name
+func validateBirthMonth(month string) error {
+	if n, err := strconv.Atoi(month); err == nil {
+		if n >= 1 && n <= 12 {
+			return nil
+		}
+		return fmt.Errorf("birth_month must be between 1 and 12")
+	}
+	for m := time.January; m <= time.December; m++ {
+		if strings.EqualFold(month, m.String()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("birth_month must be a month number or name")
+}
+
+// validateBirthYear accepts years from 1900 up to the current year
+func validateBirthYear(year int64) error {
+	if year < minBirthYear || year > int64(time.Now().Year()) {
+		return fmt.Errorf("birth_year must be between %d and %d", minBirthYear, time.Now().Year())
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Package authn turns the credentials on an incoming request into a Principal. The
+// HTTP gateway and the gRPC services use the same authenticators through thin
+// adapters, so a bearer token, a cookie, an API key or a client certificate all end up
+// as one value in the request context.
+package authn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+)
+
+// Method says how a principal proved its identity
+type Method string
+
+const (
+	MethodBearer Method = "bearer"
+	MethodCookie Method = "cookie"
+	MethodAPIKey Method = "api_key"
+	MethodMTLS   Method = "mtls"
+)
+
+var (
+	// ErrNoCredentials means the request does not carry the credential an
+	// authenticator looks for, so a Chain moves on to the next one
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrUnavailable wraps failures of the authenticator itself, such as an
+	// unreachable key store, as opposed to bad credentials
+	ErrUnavailable = errors.New("authentication unavailable")
+)
+
+// Principal is the authenticated caller
+type Principal struct {
+	// Subject is the rider ID or the peer certificate's name. Partners have none.
+	Subject string
+	// ClientID is the partner API key behind the credential, empty for everyone else
+	ClientID      string
+	Email         string
+	EmailVerified bool
+	Roles         []string
+	Scopes        []string
+	Method        Method
+	SessionID     string
+	// ExpiresAt is when the credential stops being valid, zero if it does not expire
+	ExpiresAt time.Time
+	// Credential, "Bearer <token>", is forwarded to downstream services on the
+	// principal's behalf; empty for principals without a token
+	Credential string
+	// Claims are the verified token's claims, nil for principals without a token
+	Claims *jwtlib.CustomClaims
+}
+
+// HasRole reports whether the principal holds role
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// HasScope reports whether the principal was granted scope
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// Authenticator finds and checks one kind of credential. It returns ErrNoCredentials
+// when the request does not carry that kind at all, and any other error when the
+// credential is present but not acceptable.
+type Authenticator interface {
+	Authenticate(ctx context.Context, req Request) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to Authenticator
+type AuthenticatorFunc func(ctx context.Context, req Request) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, req Request) (*Principal, error) {
+	return f(ctx, req)
+}
+
+// Chain tries authenticators in order and returns the result of the first one that
+// finds its credential. A present but invalid credential ends the chain; it never
+// falls through to a weaker method. Put the authenticators for per-caller credentials
+// first: a transport credential such as MTLS is present on every call over the
+// connection, so anything listed after it is never consulted.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, req Request) (*Principal, error) {
+		for _, authenticator := range authenticators {
+			principal, err := authenticator.Authenticate(ctx, req)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			return principal, err
+		}
+		return nil, ErrNoCredentials
+	})
+}
+
+type principalKey struct{}
+
+// WithPrincipal records the authenticated principal in ctx
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal recorded by the HTTP middleware or the
+// gRPC interceptors
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// SubjectFromContext returns the authenticated principal's subject, the rider ID for
+// riders
+func SubjectFromContext(ctx context.Context) (string, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.Subject == "" {
+		return "", fmt.Errorf("no authenticated principal in context")
+	}
+	return principal.Subject, nil
+}
@@ -0,0 +1,31 @@
+package authn
+
+import (
+	"context"
+)
+
+// MTLS authenticates callers by their verified client certificate. The subject is the
+// certificate's first URI SAN, such as a SPIFFE ID, or else its common name. roles are
+// granted to every such caller, e.g. a service role that the policy requires for
+// internal RPCs. When a gateway forwards rider calls over the same connection, chain
+// MTLS after Bearer so the rider's token decides.
+func MTLS(roles ...string) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, req Request) (*Principal, error) {
+		state := req.TLS()
+		if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+			return nil, ErrNoCredentials
+		}
+
+		cert := state.VerifiedChains[0][0]
+		subject := cert.Subject.CommonName
+		if len(cert.URIs) > 0 {
+			subject = cert.URIs[0].String()
+		}
+
+		return &Principal{
+			Subject: subject,
+			Roles:   roles,
+			Method:  MethodMTLS,
+		}, nil
+	})
+}
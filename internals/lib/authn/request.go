@@ -0,0 +1,88 @@
+package authn
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Request is the transport-neutral view of an incoming request that authenticators
+// read credentials from
+type Request interface {
+	// Header returns an HTTP header or gRPC metadata value, empty when absent
+	Header(name string) string
+	// Cookie returns a cookie's value, empty when absent
+	Cookie(name string) string
+	// TLS returns the connection's TLS state, nil for plaintext connections
+	TLS() *tls.ConnectionState
+}
+
+// FromHTTP adapts an HTTP request
+func FromHTTP(r *http.Request) Request {
+	return httpRequest{r}
+}
+
+type httpRequest struct {
+	r *http.Request
+}
+
+func (h httpRequest) Header(name string) string {
+	return h.r.Header.Get(name)
+}
+
+func (h httpRequest) Cookie(name string) string {
+	cookie, err := h.r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func (h httpRequest) TLS() *tls.ConnectionState {
+	return h.r.TLS
+}
+
+// FromGRPC adapts the context of an incoming gRPC call
+func FromGRPC(ctx context.Context) Request {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &tlsInfo.State
+		}
+	}
+	return grpcRequest{md: md, tls: state}
+}
+
+type grpcRequest struct {
+	md  metadata.MD
+	tls *tls.ConnectionState
+}
+
+func (g grpcRequest) Header(name string) string {
+	values := g.md.Get(strings.ToLower(name))
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Cookie reads the cookie metadata that HTTP-to-gRPC proxies pass along
+func (g grpcRequest) Cookie(name string) string {
+	r := http.Request{Header: http.Header{"Cookie": g.md.Get("cookie")}}
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func (g grpcRequest) TLS() *tls.ConnectionState {
+	return g.tls
+}
@@ -0,0 +1,110 @@
+package authn
+
+import (
+	"context"
+	"strings"
+
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+)
+
+// Bearer authenticates the access token in the Authorization header. opts are the
+// usual verification options; pass jwtlib.CheckRevocation to reject revoked tokens.
+func Bearer(keys jwtlib.KeySource, opts ...jwtlib.VerifyOption) Authenticator {
+	return tokenAuthenticator{
+		method: MethodBearer,
+		keys:   keys,
+		opts:   opts,
+		verify: jwtlib.VerifyAccessToken,
+		extract: func(req Request) string {
+			return req.Header("Authorization")
+		},
+	}
+}
+
+// Partner authenticates a partner token (jwtlib.GeneratePartnerToken) in the
+// Authorization header, as forwarded by the gateway for API key callers. Other tokens
+// are left to the next authenticator, so chain it before Bearer. The principal has the
+// key as ClientID and no Subject.
+func Partner(keys jwtlib.KeySource, opts ...jwtlib.VerifyOption) Authenticator {
+	return tokenAuthenticator{
+		method: MethodAPIKey,
+		keys:   keys,
+		opts:   opts,
+		verify: jwtlib.VerifyPartnerToken,
+		extract: func(req Request) string {
+			value := req.Header("Authorization")
+			// Only routes on the unverified type, the token is fully verified below
+			claims, err := jwtlib.PeekClaims(bearerToken(value))
+			if err != nil || claims.TokenType != jwtlib.TokenTypePartner {
+				return ""
+			}
+			return value
+		},
+	}
+}
+
+// Cookie authenticates the access token stored in the named cookie
+func Cookie(name string, keys jwtlib.KeySource, opts ...jwtlib.VerifyOption) Authenticator {
+	return tokenAuthenticator{
+		method: MethodCookie,
+		keys:   keys,
+		opts:   opts,
+		verify: jwtlib.VerifyAccessToken,
+		extract: func(req Request) string {
+			return req.Cookie(name)
+		},
+	}
+}
+
+type tokenAuthenticator struct {
+	method  Method
+	keys    jwtlib.KeySource
+	opts    []jwtlib.VerifyOption
+	verify  func(tokenString string, keys jwtlib.KeySource, opts ...jwtlib.VerifyOption) (*jwtlib.CustomClaims, error)
+	extract func(req Request) string
+}
+
+func (t tokenAuthenticator) Authenticate(ctx context.Context, req Request) (*Principal, error) {
+	value := t.extract(req)
+	if value == "" {
+		return nil, ErrNoCredentials
+	}
+
+	token := bearerToken(value)
+	if token == "" {
+		return nil, jwtlib.ErrMalformed
+	}
+
+	claims, err := t.verify(token, t.keys, t.opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := PrincipalFromClaims(claims, t.method)
+	principal.Credential = "Bearer " + token
+	return principal, nil
+}
+
+// bearerToken extracts the token from "Bearer <token>" format
+func bearerToken(value string) string {
+	return strings.TrimSpace(strings.TrimPrefix(value, "Bearer"))
+}
+
+// PrincipalFromClaims describes the holder of a verified access or partner token
+func PrincipalFromClaims(claims *jwtlib.CustomClaims, method Method) *Principal {
+	principal := &Principal{
+		Subject:       claims.UserID,
+		ClientID:      claims.ClientID,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Roles:         claims.Roles,
+		Scopes:        claims.Scopes(),
+		Method:        method,
+		SessionID:     claims.SessionID,
+		Claims:        claims,
+	}
+	if claims.ExpiresAt != nil {
+		principal.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return principal
+}
@@ -0,0 +1,82 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+)
+
+func TestPartnerAndBearer(t *testing.T) {
+	keyring, err := jwtlib.NewSingleKeyring("test-secret-test-secret-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := jwtlib.GenerateAccessToken("rider@example.com", "rider-1", keyring, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partnerToken, err := jwtlib.GeneratePartnerToken("key-1", keyring, time.Minute, jwtlib.WithScopes("checkout:create"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authenticator Authenticator
+		token         string
+		wantSubject   string
+		wantClientID  string
+		wantErr       error
+	}{
+		{
+			name:          "chain with an access token",
+			authenticator: Chain(Partner(keyring), Bearer(keyring)),
+			token:         accessToken,
+			wantSubject:   "rider-1",
+		},
+		{
+			name:          "chain with a partner token",
+			authenticator: Chain(Partner(keyring), Bearer(keyring)),
+			token:         partnerToken,
+			wantClientID:  "key-1",
+		},
+		{
+			name:          "bearer refuses a partner token",
+			authenticator: Bearer(keyring),
+			token:         partnerToken,
+			wantErr:       jwtlib.ErrWrongTokenType,
+		},
+		{
+			name:          "partner leaves access tokens alone",
+			authenticator: Partner(keyring),
+			token:         accessToken,
+			wantErr:       ErrNoCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			principal, err := tt.authenticator.Authenticate(context.Background(), FromHTTP(r))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if principal.Subject != tt.wantSubject || principal.ClientID != tt.wantClientID {
+				t.Errorf("principal = subject %q client %q, want subject %q client %q", principal.Subject, principal.ClientID, tt.wantSubject, tt.wantClientID)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Package authz decides whether a verified token may perform an operation. The HTTP
+// gateway and the gRPC services share it so both refuse with the same codes.
+package authz
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	RoleRider   = "rider"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+	// RolePartner is held by principals authenticated with a partner API key
+	RolePartner = "partner"
+	// RoleService is for internal services, typically granted with authn.MTLS
+	RoleService = "service"
+)
+
+// Machine readable reasons carried by DeniedError
+const (
+	CodeInsufficientRole  = "insufficient_role"
+	CodeInsufficientScope = "insufficient_scope"
+)
+
+// Requirement is what a token needs for an operation: at least one of AnyRole, when
+// given, and every one of AllScopes
+type Requirement struct {
+	AnyRole   []string
+	AllScopes []string
+}
+
+// Roles requires any one of roles
+func Roles(roles ...string) Requirement {
+	return Requirement{AnyRole: roles}
+}
+
+// Scopes requires all of scopes
+func Scopes(scopes ...string) Requirement {
+	return Requirement{AllScopes: scopes}
+}
+
+// DeniedError explains why a token was refused. It maps to HTTP 403 and gRPC
+// PermissionDenied.
+type DeniedError struct {
+	Code    string
+	Message string
+}
+
+func (e *DeniedError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Grants is what Check inspects: verified token claims or an authenticated principal
+type Grants interface {
+	HasRole(role string) bool
+	HasScope(scope string) bool
+}
+
+// Check returns a *DeniedError if grants do not meet req
+func Check(grants Grants, req Requirement) error {
+	if len(req.AnyRole) > 0 {
+		allowed := false
+		for _, role := range req.AnyRole {
+			if grants.HasRole(role) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &DeniedError{
+				Code:    CodeInsufficientRole,
+				Message: fmt.Sprintf("requires role %s", strings.Join(req.AnyRole, " or ")),
+			}
+		}
+	}
+
+	var missing []string
+	for _, scope := range req.AllScopes {
+		if !grants.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return &DeniedError{
+			Code:    CodeInsufficientScope,
+			Message: fmt.Sprintf("requires scope %s", strings.Join(missing, " ")),
+		}
+	}
+
+	return nil
+}
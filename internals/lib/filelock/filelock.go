@@ -0,0 +1,5 @@
+// Package filelock serialises writers of a shared JSON store across processes. Stores
+// that several gateway replicas or services open from the same path take the lock,
+// reload the file, apply their change and save it before releasing the lock, so no
+// writer overwrites another's change with a stale copy.
+package filelock
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package filelock
+
+// Lock is a no-op where flock is unavailable; each store's mutex still serialises
+// writers within one process
+func Lock(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,80 @@
+package jwt
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Errors returned by VerifyToken. Middlewares use errors.Is or ErrorCode to tell them apart.
+var (
+	ErrMalformed      = errors.New("token is malformed")
+	ErrBadSignature   = errors.New("token signature is invalid")
+	ErrBadAlgorithm   = errors.New("token signed with unexpected algorithm")
+	ErrUnknownKey     = errors.New("token signed with unknown key")
+	ErrKeyRetired     = errors.New("token signed with retired key")
+	ErrExpired        = errors.New("token has expired")
+	ErrNotYetValid    = errors.New("token is not valid yet")
+	ErrWrongIssuer    = errors.New("token has wrong issuer")
+	ErrWrongAudience  = errors.New("token has wrong audience")
+	ErrMissingClaim   = errors.New("token is missing a required claim")
+	ErrWrongTokenType = errors.New("token has wrong token type")
+	ErrTokenRevoked   = errors.New("token has been revoked")
+	ErrKeyNotAllowed  = errors.New("token type not allowed for its signing key")
+
+	ErrNoSigningKey = errors.New("keyring has no active signing key")
+)
+
+// ErrorCode returns a short machine readable code for a VerifyToken error, suitable
+// for the error field of an HTTP response or a gRPC status message
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrExpired):
+		return "token_expired"
+	case errors.Is(err, ErrNotYetValid):
+		return "token_not_yet_valid"
+	case errors.Is(err, ErrTokenRevoked):
+		return "token_revoked"
+	case errors.Is(err, ErrWrongIssuer):
+		return "wrong_issuer"
+	case errors.Is(err, ErrWrongAudience):
+		return "wrong_audience"
+	case errors.Is(err, ErrBadAlgorithm):
+		return "bad_algorithm"
+	case errors.Is(err, ErrMissingClaim):
+		return "missing_claim"
+	case errors.Is(err, ErrWrongTokenType), errors.Is(err, ErrKeyNotAllowed):
+		return "wrong_token_type"
+	case errors.Is(err, ErrUnknownKey), errors.Is(err, ErrKeyRetired):
+		return "unknown_key"
+	default:
+		return "invalid_token"
+	}
+}
+
+// classifyError maps the jwt library's validation errors onto this package's errors.
+// Errors returned from the keyfunc are already ours and pass through.
+func classifyError(err error) error {
+	for _, own := range []error{ErrBadAlgorithm, ErrUnknownKey, ErrKeyRetired, ErrNoSigningKey} {
+		if errors.Is(err, own) {
+			return own
+		}
+	}
+
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ErrNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return ErrWrongIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return ErrWrongAudience
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return ErrBadSignature
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ErrMalformed
+	default:
+		return ErrMalformed
+	}
+}
@@ -0,0 +1,251 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksMinRefreshInterval bounds how often an unknown kid can force a refetch
+const jwksMinRefreshInterval = 30 * time.Second
+
+// JWK is a single public key in JSON Web Key form (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// OKP (Ed25519)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+
+	// TokenTypes is not part of RFC 7517. It carries Key.TokenTypes so that services
+	// loading the document keep the key's limits.
+	TokenTypes []TokenType `json:"token_types,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKSet is a parsed, static JWKS document usable as a KeySource
+type JWKSet struct {
+	keys map[string]Key
+}
+
+// ParseJWKS parses a JWKS document. Keys of unsupported types are skipped.
+func ParseJWKS(data []byte) (*JWKSet, error) {
+	var doc JWKS
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	set := &JWKSet{keys: make(map[string]Key, len(doc.Keys))}
+	for _, jwk := range doc.Keys {
+		key, err := jwk.key()
+		if err != nil {
+			continue
+		}
+		set.keys[key.ID] = key
+	}
+	return set, nil
+}
+
+// LoadJWKSFile reads a static JWKS document from disk
+func LoadJWKSFile(path string) (*JWKSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	return ParseJWKS(data)
+}
+
+func (s *JWKSet) VerificationKey(kid string) (Key, error) {
+	key, ok := s.keys[kid]
+	if !ok {
+		return Key{}, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// RemoteJWKS fetches a JWKS document over HTTP and caches it for ttl. An unknown kid
+// triggers an early refetch so newly rotated keys are picked up, and a failed fetch
+// falls back to the last good document. Fetches start at most once per
+// jwksMinRefreshInterval, successful or not, and run without holding the lock, so
+// verifications carry on with the cached keys while the JWKS URL is slow or down.
+type RemoteJWKS struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu          sync.Mutex
+	set         *JWKSet
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	lastErr     error
+}
+
+func NewRemoteJWKS(url string, ttl time.Duration) *RemoteJWKS {
+	return &RemoteJWKS{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewJWKSSource returns a RemoteJWKS for http(s) locations and a static file otherwise
+func NewJWKSSource(location string, ttl time.Duration) (KeySource, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return NewRemoteJWKS(location, ttl), nil
+	}
+	return LoadJWKSFile(location)
+}
+
+func (r *RemoteJWKS) VerificationKey(kid string) (Key, error) {
+	set, err := r.current(false)
+	if set == nil {
+		return Key{}, err
+	}
+
+	key, err := set.VerificationKey(kid)
+	if err == ErrUnknownKey {
+		if refreshed, _ := r.current(true); refreshed != nil && refreshed != set {
+			key, err = refreshed.VerificationKey(kid)
+		}
+	}
+	return key, err
+}
+
+// current returns the cached document, first refetching it when it is older than ttl,
+// or when force is set, unless a fetch started within jwksMinRefreshInterval
+func (r *RemoteJWKS) current(force bool) (*JWKSet, error) {
+	r.mu.Lock()
+	now := time.Now()
+	set := r.set
+	due := set == nil || force || now.Sub(r.fetchedAt) > r.ttl
+	if !due || now.Sub(r.lastAttempt) < jwksMinRefreshInterval {
+		err := r.lastErr
+		r.mu.Unlock()
+		if set == nil && err == nil {
+			err = fmt.Errorf("jwks: %s has not been fetched yet", r.url)
+		}
+		return set, err
+	}
+	r.lastAttempt = now
+	r.mu.Unlock()
+
+	fetched, err := r.fetch()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastErr = err
+	if err != nil {
+		return r.set, err
+	}
+	r.set = fetched
+	r.fetchedAt = now
+	return fetched, nil
+}
+
+// fetch downloads and parses the document
+func (r *RemoteJWKS) fetch() (*JWKSet, error) {
+	resp, err := r.client.Get(r.url)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: failed to fetch %s: %w", r.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: failed to fetch %s: status %d", r.url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("jwks: failed to read %s: %w", r.url, err)
+	}
+
+	return ParseJWKS(data)
+}
+
+// publicJWK encodes the public half of an asymmetric key
+func publicJWK(key Key) (JWK, error) {
+	switch pub := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty:        "RSA",
+			Kid:        key.ID,
+			Use:        "sig",
+			Alg:        AlgRS256,
+			N:          base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:          base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			TokenTypes: key.TokenTypes,
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty:        "OKP",
+			Kid:        key.ID,
+			Use:        "sig",
+			Alg:        AlgEdDSA,
+			Crv:        "Ed25519",
+			X:          base64.RawURLEncoding.EncodeToString(pub),
+			TokenTypes: key.TokenTypes,
+		}, nil
+	default:
+		return JWK{}, fmt.Errorf("jwks: unsupported key type %T", key.PublicKey)
+	}
+}
+
+// key decodes a JWK into a verify-only Key
+func (j JWK) key() (Key, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return Key{}, fmt.Errorf("jwks: kid %q: invalid modulus: %w", j.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return Key{}, fmt.Errorf("jwks: kid %q: invalid exponent: %w", j.Kid, err)
+		}
+		return normalizeKey(Key{
+			ID:        j.Kid,
+			Algorithm: AlgRS256,
+			PublicKey: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			},
+			TokenTypes: j.TokenTypes,
+		})
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return Key{}, fmt.Errorf("jwks: kid %q: unsupported curve %q", j.Kid, j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return Key{}, fmt.Errorf("jwks: kid %q: invalid Ed25519 key", j.Kid)
+		}
+		return normalizeKey(Key{
+			ID:         j.Kid,
+			Algorithm:  AlgEdDSA,
+			PublicKey:  ed25519.PublicKey(x),
+			TokenTypes: j.TokenTypes,
+		})
+	default:
+		return Key{}, fmt.Errorf("jwks: kid %q: unsupported key type %q", j.Kid, j.Kty)
+	}
+}
@@ -0,0 +1,113 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksServer serves keyring's JWKS for the first request and passes later ones to after
+func jwksServer(t *testing.T, keyring *Keyring, after http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	doc, err := json.Marshal(keyring.JWKS())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			w.Write(doc)
+			return
+		}
+		after(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+// expireBackoff lets the next VerificationKey fetch as if jwksMinRefreshInterval had passed
+func expireBackoff(remote *RemoteJWKS) {
+	remote.mu.Lock()
+	remote.lastAttempt = time.Time{}
+	remote.mu.Unlock()
+}
+
+func testEdDSAKeyring(t *testing.T) *Keyring {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := NewKeyring("k1", Key{ID: "k1", Algorithm: AlgEdDSA, PrivateKey: private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestRemoteJWKSBacksOffWhileDown(t *testing.T) {
+	server, hits := jwksServer(t, testEdDSAKeyring(t), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	remote := NewRemoteJWKS(server.URL, time.Nanosecond)
+	if _, err := remote.VerificationKey("k1"); err != nil {
+		t.Fatalf("VerificationKey() error = %v", err)
+	}
+	expireBackoff(remote)
+
+	for i := 0; i < 10; i++ {
+		if _, err := remote.VerificationKey("k1"); err != nil {
+			t.Fatalf("VerificationKey() error = %v, want the cached key", err)
+		}
+		if _, err := remote.VerificationKey("unknown"); err != ErrUnknownKey {
+			t.Fatalf("VerificationKey(unknown) error = %v, want ErrUnknownKey", err)
+		}
+	}
+
+	// The first fetch, then one failed refresh within jwksMinRefreshInterval
+	if got := hits.Load(); got != 2 {
+		t.Errorf("fetches = %d, want 2", got)
+	}
+}
+
+func TestRemoteJWKSFetchesWithoutLock(t *testing.T) {
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	server, _ := jwksServer(t, testEdDSAKeyring(t), func(w http.ResponseWriter, r *http.Request) {
+		close(fetching)
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer close(release)
+	remote := NewRemoteJWKS(server.URL, time.Nanosecond)
+
+	if _, err := remote.VerificationKey("k1"); err != nil {
+		t.Fatalf("VerificationKey() error = %v", err)
+	}
+	expireBackoff(remote)
+
+	go remote.VerificationKey("k1")
+	<-fetching
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := remote.VerificationKey("k1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("VerificationKey() during a refresh error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("VerificationKey() blocked behind the refresh")
+	}
+}
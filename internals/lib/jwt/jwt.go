@@ -1,44 +1,208 @@
 package jwt
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type CustomClaims struct {
-	Email  string `json:"email"`
-	UserID string `json:"userId"`
+	Email         string    `json:"email"`
+	UserID        string    `json:"userId"`
+	TokenType     TokenType `json:"token_type,omitempty"`
+	EmailVerified bool      `json:"email_verified"`
+	SessionID     string    `json:"sid,omitempty"`
+	Roles         []string  `json:"roles,omitempty"`
+	Scope         string    `json:"scope,omitempty"`
+	ClientID      string    `json:"client_id,omitempty"` // the API key behind a partner token, which has no UserID
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(email string, userID string, secretKey string, duration time.Duration) (string, error) {
+// HasRole reports whether the token grants role
+func (c *CustomClaims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// Scopes splits the space separated scope claim (RFC 8693)
+func (c *CustomClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether the token grants scope
+func (c *CustomClaims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes(), scope)
+}
+
+// GenerateToken signs a token with the keyring's active key and records its kid in the header.
+// The token carries no token_type, so VerifyAccessToken and the other typed verifiers
+// reject it; services should mint tokens with the purpose-specific helpers.
+func GenerateToken(email string, userID string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	tokenID, err := NewTokenID()
+	if err != nil {
+		return "", err
+	}
+
 	claims := CustomClaims{
 		Email:  email,
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secretKey))
-}
+	for _, opt := range opts {
+		opt(&claims)
+	}
+
+	key, err := keyring.Active()
+	if err != nil {
+		return "", err
+	}
+	if !key.Allows(claims.TokenType) {
+		return "", fmt.Errorf("%w: kid %q cannot sign %q tokens", ErrKeyNotAllowed, key.ID, claims.TokenType)
+	}
 
-func VerifyToken(tokenString string, secretKey string) (*CustomClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
-	})
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
+}
 
+// VerifyToken checks a token against the key named by its kid header. The token's alg
+// must match the key's algorithm, so a public RSA or Ed25519 key can never be used as
+// an HMAC secret. Failures are reported as this package's typed errors (ErrExpired,
+// ErrWrongAudience, ErrBadAlgorithm, ...). A key limited to some token types rejects
+// tokens of any other type with ErrKeyNotAllowed.
+func VerifyToken(tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	claims := &CustomClaims{}
+	key, err := parseVerified(tokenString, keys, claims, opts...)
 	if err != nil {
 		return nil, err
 	}
+	if !key.Allows(claims.TokenType) {
+		return nil, fmt.Errorf("%w: kid %q cannot sign %q tokens", ErrKeyNotAllowed, key.ID, claims.TokenType)
+	}
+
+	var cfg verifyConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := CheckRevoked(cfg.revocations, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// ParseVerified is VerifyToken for tokens with their own claims type, such as ID tokens
+// from an external OpenID provider. It applies every option except CheckRevocation.
+func ParseVerified(tokenString string, keys KeySource, claims jwt.Claims, opts ...VerifyOption) error {
+	_, err := parseVerified(tokenString, keys, claims, opts...)
+	return err
+}
 
-	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-		return claims, nil
+// parseVerified is ParseVerified that also returns the key that checked the signature
+func parseVerified(tokenString string, keys KeySource, claims jwt.Claims, opts ...VerifyOption) (Key, error) {
+	var cfg verifyConfig
+	for _, opt := range opts {
+		opt(&cfg)
 	}
 
-	return nil, jwt.ErrSignatureInvalid
+	parserOpts := []jwt.ParserOption{jwt.WithLeeway(cfg.leeway)}
+	if len(cfg.algorithms) > 0 {
+		parserOpts = append(parserOpts, jwt.WithValidMethods(cfg.algorithms))
+	}
+	if cfg.issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(cfg.issuer))
+	}
+	if len(cfg.audiences) > 0 {
+		parserOpts = append(parserOpts, jwt.WithAudience(cfg.audiences...))
+	}
+
+	var key Key
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		var err error
+		key, err = keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, ErrBadAlgorithm
+		}
+		return key.verificationKey(), nil
+	}, parserOpts...)
+
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenSignatureInvalid) && token != nil && len(cfg.algorithms) > 0 {
+			// WithValidMethods reports a disallowed alg as an invalid signature
+			if alg, _ := token.Header["alg"].(string); !slices.Contains(cfg.algorithms, alg) {
+				return Key{}, ErrBadAlgorithm
+			}
+		}
+		return Key{}, classifyError(err)
+	}
+
+	if !token.Valid {
+		return Key{}, ErrBadSignature
+	}
+
+	return key, checkRequiredClaims(tokenString, cfg.claims)
+}
+
+// checkRequiredClaims compares claims by their JSON encoding so that a RequireClaim
+// value of any type matches what was decoded from the token
+func checkRequiredClaims(tokenString string, required map[string]interface{}) error {
+	if len(required) == 0 {
+		return nil
+	}
+
+	raw := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, raw); err != nil {
+		return ErrMalformed
+	}
+
+	for name, want := range required {
+		got, ok := raw[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrMissingClaim, name)
+		}
+
+		wantJSON, _ := json.Marshal(want)
+		gotJSON, _ := json.Marshal(got)
+		if !bytes.Equal(wantJSON, gotJSON) {
+			return fmt.Errorf("%w: %s", ErrMissingClaim, name)
+		}
+	}
+	return nil
+}
+
+// PeekClaims decodes a token's claims WITHOUT verifying its signature or expiry.
+// Only use it where acting on a forged token is harmless, e.g. revoking it on logout.
+func PeekClaims(tokenString string) (*CustomClaims, error) {
+	claims := &CustomClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// NewTokenID returns a random identifier suitable for the jti claim
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,415 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultKeyID is the kid given to a key loaded from the legacy ACCESS_TOKEN_SECRET_KEY
+// variable. Tokens issued before key rotation carry no kid and are checked against it.
+const DefaultKeyID = "default"
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// KeySource resolves the key that verifies a token from its kid header.
+// *Keyring, *JWKSet and *RemoteJWKS implement it.
+type KeySource interface {
+	VerificationKey(kid string) (Key, error)
+}
+
+// Key is one signing or verification key. HS256 keys use Secret; RS256 and EdDSA keys
+// use PrivateKey to sign and PublicKey to verify, and a verify-only key has no
+// PrivateKey. A zero RetiresAt means the key never retires. TokenTypes limits which
+// token types the key signs and verifies, so a key held by the gateway for its emailed
+// links cannot mint access tokens; an empty list allows every type.
+type Key struct {
+	ID         string
+	Algorithm  string
+	Secret     string
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+	RetiresAt  time.Time
+	TokenTypes []TokenType
+}
+
+// Keyring holds the active signing key and the retired keys that still verify
+type Keyring struct {
+	activeID string
+	keys     map[string]Key
+}
+
+// keyringFile is the on-disk layout read by LoadKeyringFile
+type keyringFile struct {
+	ActiveKID string    `json:"active_kid"`
+	Keys      []keySpec `json:"keys"`
+}
+
+type keySpec struct {
+	ID             string      `json:"kid"`
+	Algorithm      string      `json:"alg"`
+	Secret         string      `json:"secret"`
+	PrivateKeyFile string      `json:"private_key_file"`
+	PublicKeyFile  string      `json:"public_key_file"`
+	RetiresAt      time.Time   `json:"retires_at,omitempty"`
+	TokenTypes     []TokenType `json:"token_types,omitempty"`
+}
+
+// NewKeyring builds a keyring signing with activeID. An empty activeID gives a
+// verify-only keyring, as used by services that must not be able to mint tokens.
+func NewKeyring(activeID string, keys ...Key) (*Keyring, error) {
+	k := &Keyring{
+		activeID: activeID,
+		keys:     make(map[string]Key, len(keys)),
+	}
+
+	for _, key := range keys {
+		key, err := normalizeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("keyring: duplicate kid %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+
+	if activeID == "" {
+		return k, nil
+	}
+
+	active, ok := k.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("keyring: active kid %q not found", activeID)
+	}
+	if !active.RetiresAt.IsZero() {
+		return nil, fmt.Errorf("keyring: active kid %q must not have a retirement date", activeID)
+	}
+	if !active.canSign() {
+		return nil, fmt.Errorf("keyring: active kid %q has no private key", activeID)
+	}
+
+	return k, nil
+}
+
+// NewSingleKeyring wraps one HS256 secret as the DefaultKeyID key
+func NewSingleKeyring(secret string) (*Keyring, error) {
+	return NewKeyring(DefaultKeyID, Key{ID: DefaultKeyID, Algorithm: AlgHS256, Secret: secret})
+}
+
+// Active returns the key new tokens are signed with
+func (k *Keyring) Active() (Key, error) {
+	if k.activeID == "" {
+		return Key{}, ErrNoSigningKey
+	}
+	return k.keys[k.activeID], nil
+}
+
+// Lookup returns the key for a token's kid header, rejecting keys retired before now
+func (k *Keyring) Lookup(kid string, now time.Time) (Key, error) {
+	if kid == "" {
+		kid = DefaultKeyID
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return Key{}, ErrUnknownKey
+	}
+	if !key.RetiresAt.IsZero() && !now.Before(key.RetiresAt) {
+		return Key{}, ErrKeyRetired
+	}
+	return key, nil
+}
+
+func (k *Keyring) VerificationKey(kid string) (Key, error) {
+	return k.Lookup(kid, time.Now())
+}
+
+// CanSign reports whether any key that has not retired could sign a token of
+// tokenType, whether or not it is the active key
+func (k *Keyring) CanSign(tokenType TokenType) bool {
+	now := time.Now()
+	for _, key := range k.keys {
+		if !key.RetiresAt.IsZero() && !now.Before(key.RetiresAt) {
+			continue
+		}
+		if key.canSign() && key.Allows(tokenType) {
+			return true
+		}
+	}
+	return false
+}
+
+// JWKS returns the public halves of the asymmetric keys that still verify.
+// HS256 secrets are never published.
+func (k *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	now := time.Now()
+
+	for _, key := range k.keys {
+		if key.Algorithm == AlgHS256 {
+			continue
+		}
+		if !key.RetiresAt.IsZero() && !now.Before(key.RetiresAt) {
+			continue
+		}
+		if jwk, err := publicJWK(key); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// Allows reports whether the key may sign and verify tokens of tokenType
+func (key Key) Allows(tokenType TokenType) bool {
+	return len(key.TokenTypes) == 0 || slices.Contains(key.TokenTypes, tokenType)
+}
+
+func (key Key) canSign() bool {
+	if key.Algorithm == AlgHS256 {
+		return key.Secret != ""
+	}
+	return key.PrivateKey != nil
+}
+
+// signingMethod maps the key's algorithm to the jwt library's signing method
+func (key Key) signingMethod() jwt.SigningMethod {
+	switch key.Algorithm {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func (key Key) signingKey() interface{} {
+	if key.Algorithm == AlgHS256 {
+		return []byte(key.Secret)
+	}
+	return key.PrivateKey
+}
+
+func (key Key) verificationKey() interface{} {
+	if key.Algorithm == AlgHS256 {
+		return []byte(key.Secret)
+	}
+	return key.PublicKey
+}
+
+// normalizeKey fills in defaults and checks that the key material matches its algorithm
+func normalizeKey(key Key) (Key, error) {
+	if key.ID == "" {
+		return Key{}, fmt.Errorf("keyring: every key needs a kid")
+	}
+	if key.Algorithm == "" {
+		key.Algorithm = AlgHS256
+	}
+	if key.PublicKey == nil && key.PrivateKey != nil {
+		key.PublicKey = key.PrivateKey.Public()
+	}
+	for _, tokenType := range key.TokenTypes {
+		if !tokenType.known() {
+			return Key{}, fmt.Errorf("keyring: key %q lists unknown token type %q", key.ID, tokenType)
+		}
+	}
+
+	switch key.Algorithm {
+	case AlgHS256:
+		if key.Secret == "" {
+			return Key{}, fmt.Errorf("keyring: HS256 key %q needs a secret", key.ID)
+		}
+	case AlgRS256:
+		if _, ok := key.PublicKey.(*rsa.PublicKey); !ok {
+			return Key{}, fmt.Errorf("keyring: RS256 key %q needs an RSA key", key.ID)
+		}
+		if key.PrivateKey != nil {
+			if _, ok := key.PrivateKey.(*rsa.PrivateKey); !ok {
+				return Key{}, fmt.Errorf("keyring: RS256 key %q needs an RSA private key", key.ID)
+			}
+		}
+	case AlgEdDSA:
+		if _, ok := key.PublicKey.(ed25519.PublicKey); !ok {
+			return Key{}, fmt.Errorf("keyring: EdDSA key %q needs an Ed25519 key", key.ID)
+		}
+		if key.PrivateKey != nil {
+			if _, ok := key.PrivateKey.(ed25519.PrivateKey); !ok {
+				return Key{}, fmt.Errorf("keyring: EdDSA key %q needs an Ed25519 private key", key.ID)
+			}
+		}
+	default:
+		return Key{}, fmt.Errorf("keyring: key %q has unsupported algorithm %q", key.ID, key.Algorithm)
+	}
+
+	return key, nil
+}
+
+// LoadKeyringFile reads a JSON keyring of the form
+//
+//	{"active_kid": "2025-06", "keys": [
+//	  {"kid": "2025-06", "alg": "EdDSA", "private_key_file": "signing.pem"},
+//	  {"kid": "2025-01", "alg": "HS256", "secret": "...", "retires_at": "2025-07-01T00:00:00Z"}]}
+//
+// Verify-only services leave active_kid empty and list public_key_file instead. A key
+// may add "token_types": ["password_reset", ...] to limit what it signs and verifies.
+// Key file paths are relative to the working directory.
+func LoadKeyringFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("keyring: failed to parse %s: %w", path, err)
+	}
+
+	keys := make([]Key, 0, len(file.Keys))
+	for _, spec := range file.Keys {
+		key := Key{
+			ID:         spec.ID,
+			Algorithm:  spec.Algorithm,
+			Secret:     spec.Secret,
+			RetiresAt:  spec.RetiresAt,
+			TokenTypes: spec.TokenTypes,
+		}
+
+		if spec.PrivateKeyFile != "" {
+			pemData, err := os.ReadFile(spec.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("keyring: %w", err)
+			}
+			if key.PrivateKey, err = ParsePrivateKeyPEM(pemData); err != nil {
+				return nil, fmt.Errorf("keyring: kid %q: %w", spec.ID, err)
+			}
+		}
+
+		if spec.PublicKeyFile != "" {
+			pemData, err := os.ReadFile(spec.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("keyring: %w", err)
+			}
+			if key.PublicKey, err = ParsePublicKeyPEM(pemData); err != nil {
+				return nil, fmt.Errorf("keyring: kid %q: %w", spec.ID, err)
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	return NewKeyring(file.ActiveKID, keys...)
+}
+
+// LoadKeyringFromEnv builds a keyring from, in order of preference:
+//   - JWT_KEYRING_FILE, a path read by LoadKeyringFile
+//   - JWT_KEYS and JWT_ACTIVE_KID, where JWT_KEYS is a comma separated list of HS256
+//     keys written kid:secret or kid:secret:retires_at (RFC 3339 or YYYY-MM-DD)
+//   - ACCESS_TOKEN_SECRET_KEY, as a single key with kid DefaultKeyID
+func LoadKeyringFromEnv() (*Keyring, error) {
+	if path := os.Getenv("JWT_KEYRING_FILE"); path != "" {
+		return LoadKeyringFile(path)
+	}
+
+	if spec := os.Getenv("JWT_KEYS"); spec != "" {
+		keys, err := parseKeySpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyring(os.Getenv("JWT_ACTIVE_KID"), keys...)
+	}
+
+	if secret := os.Getenv("ACCESS_TOKEN_SECRET_KEY"); secret != "" {
+		return NewSingleKeyring(secret)
+	}
+
+	return nil, fmt.Errorf("keyring: one of JWT_KEYRING_FILE, JWT_KEYS or ACCESS_TOKEN_SECRET_KEY is required")
+}
+
+// ParsePrivateKeyPEM parses an RSA (PKCS #1 or #8) or Ed25519 (PKCS #8) private key
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// ParsePublicKeyPEM parses a PKIX RSA or Ed25519 public key
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return key, nil
+}
+
+func parseKeySpec(spec string) ([]Key, error) {
+	var keys []Key
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("keyring: JWT_KEYS entry %q must be kid:secret[:retires_at]", entry)
+		}
+
+		key := Key{ID: parts[0], Algorithm: AlgHS256, Secret: parts[1]}
+		if len(parts) == 3 && parts[2] != "" {
+			retiresAt, err := parseRetiresAt(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("keyring: invalid retirement date for kid %q: %w", key.ID, err)
+			}
+			key.RetiresAt = retiresAt
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func parseRetiresAt(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
@@ -0,0 +1,162 @@
+package jwt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// VerifyOption tightens the checks VerifyToken performs
+type VerifyOption func(*verifyConfig)
+
+type verifyConfig struct {
+	algorithms  []string
+	issuer      string
+	audiences   []string
+	leeway      time.Duration
+	claims      map[string]interface{}
+	revocations RevocationStore
+}
+
+// AllowAlgorithms restricts the accepted alg headers. The alg must also match the
+// algorithm of the key named by the kid, whether or not this option is given.
+func AllowAlgorithms(algs ...string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.algorithms = append(c.algorithms, algs...)
+	}
+}
+
+// RequireIssuer rejects tokens whose iss claim is not iss
+func RequireIssuer(iss string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.issuer = iss
+	}
+}
+
+// RequireAudience rejects tokens whose aud claim contains none of aud
+func RequireAudience(aud ...string) VerifyOption {
+	return func(c *verifyConfig) {
+		c.audiences = append(c.audiences, aud...)
+	}
+}
+
+// WithLeeway allows for clock skew when checking exp, nbf and iat
+func WithLeeway(leeway time.Duration) VerifyOption {
+	return func(c *verifyConfig) {
+		c.leeway = leeway
+	}
+}
+
+// RequireClaim rejects tokens whose claim name is missing or not equal to value
+func RequireClaim(name string, value interface{}) VerifyOption {
+	return func(c *verifyConfig) {
+		if c.claims == nil {
+			c.claims = make(map[string]interface{})
+		}
+		c.claims[name] = value
+	}
+}
+
+// CheckRevocation rejects tokens recorded in store. A nil store disables the check.
+func CheckRevocation(store RevocationStore) VerifyOption {
+	return func(c *verifyConfig) {
+		c.revocations = store
+	}
+}
+
+// GenerateOption sets optional claims on tokens minted by GenerateToken
+type GenerateOption func(*CustomClaims)
+
+// IssuedBy sets the iss claim
+func IssuedBy(iss string) GenerateOption {
+	return func(c *CustomClaims) {
+		c.Issuer = iss
+	}
+}
+
+// IssuedFor sets the aud claim
+func IssuedFor(aud ...string) GenerateOption {
+	return func(c *CustomClaims) {
+		c.Audience = append(c.Audience, aud...)
+	}
+}
+
+// WithEmailVerified sets the email_verified claim carried by access tokens
+func WithEmailVerified(verified bool) GenerateOption {
+	return func(c *CustomClaims) {
+		c.EmailVerified = verified
+	}
+}
+
+// WithSessionID sets the sid claim naming the login session a token belongs to.
+// Revoking the session ID revokes every token carrying it.
+func WithSessionID(sessionID string) GenerateOption {
+	return func(c *CustomClaims) {
+		c.SessionID = sessionID
+	}
+}
+
+// WithRoles adds to the roles claim, e.g. "support" or "admin"
+func WithRoles(roles ...string) GenerateOption {
+	return func(c *CustomClaims) {
+		c.Roles = append(c.Roles, roles...)
+	}
+}
+
+// WithScopes adds to the space separated scope claim
+func WithScopes(scopes ...string) GenerateOption {
+	return func(c *CustomClaims) {
+		c.Scope = strings.Join(append(c.Scopes(), scopes...), " ")
+	}
+}
+
+// VerifyOptionsFromEnv reads JWT_ISSUER, JWT_AUDIENCE (comma separated), JWT_ALGORITHMS
+// (comma separated) and JWT_LEEWAY (a Go duration) so every Loop service applies the
+// same checks from the same configuration
+func VerifyOptionsFromEnv() ([]VerifyOption, error) {
+	var opts []VerifyOption
+
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		opts = append(opts, RequireIssuer(iss))
+	}
+	if aud := splitList(os.Getenv("JWT_AUDIENCE")); len(aud) > 0 {
+		opts = append(opts, RequireAudience(aud...))
+	}
+	if algs := splitList(os.Getenv("JWT_ALGORITHMS")); len(algs) > 0 {
+		opts = append(opts, AllowAlgorithms(algs...))
+	}
+	if leeway := os.Getenv("JWT_LEEWAY"); leeway != "" {
+		d, err := time.ParseDuration(leeway)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_LEEWAY: %w", err)
+		}
+		opts = append(opts, WithLeeway(d))
+	}
+
+	return opts, nil
+}
+
+// GenerateOptionsFromEnv is the issuing counterpart of VerifyOptionsFromEnv
+func GenerateOptionsFromEnv() []GenerateOption {
+	var opts []GenerateOption
+
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		opts = append(opts, IssuedBy(iss))
+	}
+	if aud := splitList(os.Getenv("JWT_AUDIENCE")); len(aud) > 0 {
+		opts = append(opts, IssuedFor(aud...))
+	}
+
+	return opts
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
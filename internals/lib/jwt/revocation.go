@@ -0,0 +1,423 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/loop/backend/rider-auth/lib/filelock"
+)
+
+// RevocationStore records the IDs (jti) of tokens, and the session IDs (sid) of login
+// sessions, that must no longer be accepted, as well as per-user cutoffs before which
+// no token of the user is accepted. Entries only need to be kept until the last token
+// they cover would have expired anyway.
+type RevocationStore interface {
+	Revoke(id string, expiresAt time.Time) error
+	IsRevoked(id string) (bool, error)
+
+	// RevokeOnce is Revoke for single-use tokens: it records id only if it is not
+	// already revoked and reports whether this call recorded it, so two concurrent
+	// redemptions of one token cannot both succeed
+	RevokeOnce(id string, expiresAt time.Time) (bool, error)
+	// Unrevoke forgets id, undoing RevokeOnce for a token whose use never took effect
+	Unrevoke(id string) error
+
+	// RevokeIssuedBefore rejects every token of userID issued before cutoff, keeping
+	// the later cutoff if one is already set. expiresAt is when the last such token
+	// expires.
+	RevokeIssuedBefore(userID string, cutoff time.Time, expiresAt time.Time) error
+	// IssuedBefore returns userID's cutoff, or the zero time when there is none
+	IssuedBefore(userID string) (time.Time, error)
+}
+
+// CheckRevoked returns ErrTokenRevoked if the token's ID or its session ID is in the
+// store, or if the token was issued before its user's cutoff. A nil store disables the
+// check.
+func CheckRevoked(store RevocationStore, claims *CustomClaims) error {
+	if store == nil {
+		return nil
+	}
+
+	for _, id := range []string{claims.ID, claims.SessionID} {
+		if id == "" {
+			continue
+		}
+
+		revoked, err := store.IsRevoked(id)
+		if err != nil {
+			return fmt.Errorf("failed to check revocation: %w", err)
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+
+	if claims.UserID == "" {
+		return nil
+	}
+	cutoff, err := store.IssuedBefore(claims.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to check revocation: %w", err)
+	}
+	// iat has whole-second precision, so tokens issued in the cutoff's own second
+	// (such as the ones issued right after a password change) are kept
+	if !cutoff.IsZero() && (claims.IssuedAt == nil || claims.IssuedAt.Time.Before(cutoff.Truncate(time.Second))) {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// Redeem marks a single-use token as used until its expiry. It returns ErrTokenRevoked
+// if the token was used before, even by a concurrent request, and ErrMissingClaim if
+// the token has no ID or expiry to record.
+func Redeem(store RevocationStore, claims *CustomClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return ErrMissingClaim
+	}
+
+	recorded, err := store.RevokeOnce(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return fmt.Errorf("failed to record token use: %w", err)
+	}
+	if !recorded {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// Release undoes Redeem when the action the token was redeemed for failed before it
+// took effect, e.g. the backend could not be reached, so the link can be used again
+func Release(store RevocationStore, claims *CustomClaims) error {
+	if claims.ID == "" {
+		return ErrMissingClaim
+	}
+	if err := store.Unrevoke(claims.ID); err != nil {
+		return fmt.Errorf("failed to release token: %w", err)
+	}
+	return nil
+}
+
+// revocationList is the content of a store, and the JSON form of FileRevocationStore
+type revocationList struct {
+	Tokens map[string]time.Time   `json:"tokens"`
+	Users  map[string]issueCutoff `json:"users,omitempty"`
+}
+
+type issueCutoff struct {
+	IssuedBefore time.Time `json:"issued_before"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func newRevocationList() revocationList {
+	return revocationList{
+		Tokens: make(map[string]time.Time),
+		Users:  make(map[string]issueCutoff),
+	}
+}
+
+func (l revocationList) revoke(id string, expiresAt time.Time) {
+	l.Tokens[id] = expiresAt
+}
+
+func (l revocationList) revokeOnce(id string, expiresAt time.Time) bool {
+	if _, ok := l.Tokens[id]; ok {
+		return false
+	}
+	l.Tokens[id] = expiresAt
+	return true
+}
+
+// unrevoke reports whether id was in the list
+func (l revocationList) unrevoke(id string) bool {
+	if _, ok := l.Tokens[id]; !ok {
+		return false
+	}
+	delete(l.Tokens, id)
+	return true
+}
+
+func (l revocationList) isRevoked(id string, now time.Time) bool {
+	expiresAt, ok := l.Tokens[id]
+	return ok && now.Before(expiresAt)
+}
+
+func (l revocationList) revokeIssuedBefore(userID string, cutoff time.Time, expiresAt time.Time) {
+	current := l.Users[userID]
+	if cutoff.After(current.IssuedBefore) {
+		current.IssuedBefore = cutoff
+	}
+	if expiresAt.After(current.ExpiresAt) {
+		current.ExpiresAt = expiresAt
+	}
+	l.Users[userID] = current
+}
+
+func (l revocationList) issuedBefore(userID string, now time.Time) time.Time {
+	cutoff, ok := l.Users[userID]
+	if !ok || !now.Before(cutoff.ExpiresAt) {
+		return time.Time{}
+	}
+	return cutoff.IssuedBefore
+}
+
+func (l revocationList) prune(now time.Time) {
+	for id, expiresAt := range l.Tokens {
+		if !now.Before(expiresAt) {
+			delete(l.Tokens, id)
+		}
+	}
+	for userID, cutoff := range l.Users {
+		if !now.Before(cutoff.ExpiresAt) {
+			delete(l.Users, userID)
+		}
+	}
+}
+
+// MemoryRevocationStore keeps revoked token IDs in process memory
+type MemoryRevocationStore struct {
+	mu   sync.RWMutex
+	list revocationList
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		list: newRevocationList(),
+	}
+}
+
+func (s *MemoryRevocationStore) Revoke(id string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.list.prune(time.Now())
+	s.list.revoke(id, expiresAt)
+	return nil
+}
+
+func (s *MemoryRevocationStore) RevokeOnce(id string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.list.prune(time.Now())
+	return s.list.revokeOnce(id, expiresAt), nil
+}
+
+func (s *MemoryRevocationStore) Unrevoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.list.unrevoke(id)
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsRevoked(id string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.list.isRevoked(id, time.Now()), nil
+}
+
+func (s *MemoryRevocationStore) RevokeIssuedBefore(userID string, cutoff time.Time, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.list.prune(time.Now())
+	s.list.revokeIssuedBefore(userID, cutoff, expiresAt)
+	return nil
+}
+
+func (s *MemoryRevocationStore) IssuedBefore(userID string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.list.issuedBefore(userID, time.Now()), nil
+}
+
+// FileRevocationStore persists revoked token IDs as a JSON file so that several local
+// processes (the REST gateway and the gRPC services) can share one revocation list.
+// The file is re-read whenever it is replaced or modified, and writers hold a lock
+// on a ".lock" file next to it so updates from different processes are not lost.
+type FileRevocationStore struct {
+	mu     sync.Mutex
+	path   string
+	loaded os.FileInfo // the file as last read or written
+	list   revocationList
+}
+
+func NewFileRevocationStore(path string) (*FileRevocationStore, error) {
+	s := &FileRevocationStore{
+		path: path,
+		list: newRevocationList(),
+	}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileRevocationStore) Revoke(id string, expiresAt time.Time) error {
+	_, err := s.update(func(list revocationList) bool {
+		list.revoke(id, expiresAt)
+		return true
+	})
+	return err
+}
+
+func (s *FileRevocationStore) RevokeOnce(id string, expiresAt time.Time) (bool, error) {
+	return s.update(func(list revocationList) bool {
+		return list.revokeOnce(id, expiresAt)
+	})
+}
+
+func (s *FileRevocationStore) Unrevoke(id string) error {
+	_, err := s.update(func(list revocationList) bool {
+		return list.unrevoke(id)
+	})
+	return err
+}
+
+func (s *FileRevocationStore) IsRevoked(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+	return s.list.isRevoked(id, time.Now()), nil
+}
+
+func (s *FileRevocationStore) RevokeIssuedBefore(userID string, cutoff time.Time, expiresAt time.Time) error {
+	_, err := s.update(func(list revocationList) bool {
+		list.revokeIssuedBefore(userID, cutoff, expiresAt)
+		return true
+	})
+	return err
+}
+
+func (s *FileRevocationStore) IssuedBefore(userID string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return time.Time{}, err
+	}
+	return s.list.issuedBefore(userID, time.Now()), nil
+}
+
+// update applies change to the current list under the file lock and saves it if
+// change reports that it modified the list
+func (s *FileRevocationStore) update(change func(list revocationList) bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := filelock.Lock(s.path + ".lock")
+	if err != nil {
+		return false, fmt.Errorf("failed to lock revocation file: %w", err)
+	}
+	defer unlock()
+
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+
+	s.list.prune(time.Now())
+	if !change(s.list) {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+// reload reads the file if it changed since the last read. A missing file is an empty store.
+func (s *FileRevocationStore) reload() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat revocation file: %w", err)
+	}
+
+	// Each save renames a new file into place, so a different file with the same
+	// modification time (timestamps are coarser than writes) still counts as a change
+	if s.loaded != nil && os.SameFile(info, s.loaded) && info.ModTime().Equal(s.loaded.ModTime()) && info.Size() == s.loaded.Size() {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read revocation file: %w", err)
+	}
+
+	list, err := parseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse revocation file: %w", err)
+	}
+
+	s.list = list
+	s.loaded = info
+	return nil
+}
+
+// parseRevocationList reads the file format, and the plain map of token IDs to expiry
+// times written before per-user cutoffs existed
+func parseRevocationList(data []byte) (revocationList, error) {
+	list := newRevocationList()
+	if len(bytes.TrimSpace(data)) == 0 {
+		return list, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&list); err == nil {
+		if list.Tokens == nil {
+			list.Tokens = make(map[string]time.Time)
+		}
+		if list.Users == nil {
+			list.Users = make(map[string]issueCutoff)
+		}
+		return list, nil
+	}
+
+	list = newRevocationList()
+	if err := json.Unmarshal(data, &list.Tokens); err != nil {
+		return revocationList{}, err
+	}
+	return list, nil
+}
+
+// save writes the store to a temporary file and renames it over the original
+func (s *FileRevocationStore) save() error {
+	data, err := json.MarshalIndent(s.list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".revoked-*")
+	if err != nil {
+		return fmt.Errorf("failed to write revocation file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write revocation file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write revocation file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write revocation file: %w", err)
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.loaded = info
+	}
+	return nil
+}
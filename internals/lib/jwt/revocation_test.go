@@ -0,0 +1,268 @@
+package jwt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func revocationStores(t *testing.T) map[string]RevocationStore {
+	t.Helper()
+
+	fileStore, err := NewFileRevocationStore(filepath.Join(t.TempDir(), "revoked.json"))
+	if err != nil {
+		t.Fatalf("NewFileRevocationStore() error = %v", err)
+	}
+	return map[string]RevocationStore{
+		"memory": NewMemoryRevocationStore(),
+		"file":   fileStore,
+	}
+}
+
+func TestCheckRevoked(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		revoked map[string]time.Time
+		claims  CustomClaims
+		wantErr error
+	}{
+		{
+			name:   "nothing revoked",
+			claims: CustomClaims{SessionID: "s1", RegisteredClaims: jwt.RegisteredClaims{ID: "t1"}},
+		},
+		{
+			name:    "token ID revoked",
+			revoked: map[string]time.Time{"t1": future},
+			claims:  CustomClaims{SessionID: "s1", RegisteredClaims: jwt.RegisteredClaims{ID: "t1"}},
+			wantErr: ErrTokenRevoked,
+		},
+		{
+			name:    "session revoked",
+			revoked: map[string]time.Time{"s1": future},
+			claims:  CustomClaims{SessionID: "s1", RegisteredClaims: jwt.RegisteredClaims{ID: "t1"}},
+			wantErr: ErrTokenRevoked,
+		},
+		{
+			name:    "entry past its expiry",
+			revoked: map[string]time.Time{"t1": past},
+			claims:  CustomClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "t1"}},
+		},
+	}
+
+	for storeName, store := range revocationStores(t) {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				for id, expiresAt := range tt.revoked {
+					if err := store.Revoke(id, expiresAt); err != nil {
+						t.Fatalf("Revoke() error = %v", err)
+					}
+				}
+				t.Cleanup(func() {
+					for id := range tt.revoked {
+						store.Revoke(id, past)
+					}
+				})
+
+				err := CheckRevoked(store, &tt.claims)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CheckRevoked() error = %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestRedeemIsSingleUse(t *testing.T) {
+	for name, store := range revocationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			claims := &CustomClaims{RegisteredClaims: jwt.RegisteredClaims{
+				ID:        "reset-1",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			}}
+
+			var wg sync.WaitGroup
+			var redeemed atomic.Int32
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := Redeem(store, claims)
+					switch {
+					case err == nil:
+						redeemed.Add(1)
+					case !errors.Is(err, ErrTokenRevoked):
+						t.Errorf("Redeem() error = %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := redeemed.Load(); got != 1 {
+				t.Fatalf("token redeemed %d times, want 1", got)
+			}
+			if err := CheckRevoked(store, claims); !errors.Is(err, ErrTokenRevoked) {
+				t.Fatalf("CheckRevoked() after Redeem = %v, want ErrTokenRevoked", err)
+			}
+		})
+	}
+}
+
+func TestReleaseAllowsRedeemAgain(t *testing.T) {
+	for name, store := range revocationStores(t) {
+		t.Run(name, func(t *testing.T) {
+			claims := &CustomClaims{RegisteredClaims: jwt.RegisteredClaims{
+				ID:        "reset-1",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			}}
+
+			if err := Redeem(store, claims); err != nil {
+				t.Fatalf("Redeem() error = %v", err)
+			}
+			if err := Release(store, claims); err != nil {
+				t.Fatalf("Release() error = %v", err)
+			}
+			if err := CheckRevoked(store, claims); err != nil {
+				t.Fatalf("CheckRevoked() after Release = %v, want nil", err)
+			}
+			if err := Redeem(store, claims); err != nil {
+				t.Fatalf("Redeem() after Release error = %v", err)
+			}
+			if err := Redeem(store, claims); !errors.Is(err, ErrTokenRevoked) {
+				t.Fatalf("second Redeem() error = %v, want ErrTokenRevoked", err)
+			}
+		})
+	}
+}
+
+func TestRedeemWithoutID(t *testing.T) {
+	err := Redeem(NewMemoryRevocationStore(), &CustomClaims{})
+	if !errors.Is(err, ErrMissingClaim) {
+		t.Fatalf("Redeem() error = %v, want ErrMissingClaim", err)
+	}
+}
+
+func TestFileRevocationStoreSharedBetweenInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.json")
+	first, err := NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	if ok, err := first.RevokeOnce("t1", expiresAt); err != nil || !ok {
+		t.Fatalf("first.RevokeOnce() = %v, %v, want true", ok, err)
+	}
+	if ok, err := second.RevokeOnce("t1", expiresAt); err != nil || ok {
+		t.Fatalf("second.RevokeOnce() = %v, %v, want false", ok, err)
+	}
+	if err := second.Revoke("t2", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"t1", "t2"} {
+		if revoked, err := first.IsRevoked(id); err != nil || !revoked {
+			t.Errorf("first.IsRevoked(%q) = %v, %v, want true", id, revoked, err)
+		}
+	}
+}
+
+func TestCheckRevokedIssuedBefore(t *testing.T) {
+	cutoff := time.Date(2026, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+
+	tests := []struct {
+		name     string
+		userID   string
+		issuedAt *jwt.NumericDate
+		wantErr  error
+	}{
+		{
+			name:     "issued before the cutoff",
+			userID:   "rider-1",
+			issuedAt: jwt.NewNumericDate(cutoff.Add(-time.Minute)),
+			wantErr:  ErrTokenRevoked,
+		},
+		{
+			name:     "issued in the cutoff's second",
+			userID:   "rider-1",
+			issuedAt: jwt.NewNumericDate(cutoff.Truncate(time.Second)),
+		},
+		{
+			name:     "issued after the cutoff",
+			userID:   "rider-1",
+			issuedAt: jwt.NewNumericDate(cutoff.Add(time.Minute)),
+		},
+		{
+			name:    "no issued-at claim",
+			userID:  "rider-1",
+			wantErr: ErrTokenRevoked,
+		},
+		{
+			name:     "other user",
+			userID:   "rider-2",
+			issuedAt: jwt.NewNumericDate(cutoff.Add(-time.Minute)),
+		},
+	}
+
+	for storeName, store := range revocationStores(t) {
+		if err := store.RevokeIssuedBefore("rider-1", cutoff, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("RevokeIssuedBefore() error = %v", err)
+		}
+		// An earlier cutoff never replaces a later one
+		if err := store.RevokeIssuedBefore("rider-1", cutoff.Add(-time.Hour), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("RevokeIssuedBefore() error = %v", err)
+		}
+
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				claims := &CustomClaims{UserID: tt.userID, RegisteredClaims: jwt.RegisteredClaims{ID: "t1", IssuedAt: tt.issuedAt}}
+				if err := CheckRevoked(store, claims); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CheckRevoked() error = %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestFileRevocationStoreReadsPlainMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.json")
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	if err := os.WriteFile(path, []byte(`{"t1": "`+expiresAt+`"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatalf("NewFileRevocationStore() error = %v", err)
+	}
+	if revoked, err := store.IsRevoked("t1"); err != nil || !revoked {
+		t.Fatalf("IsRevoked() = %v, %v, want true", revoked, err)
+	}
+
+	// The next write converts the file to the current format
+	if err := store.RevokeIssuedBefore("rider-1", time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatalf("NewFileRevocationStore() error = %v", err)
+	}
+	if revoked, err := reopened.IsRevoked("t1"); err != nil || !revoked {
+		t.Errorf("IsRevoked() after rewrite = %v, %v, want true", revoked, err)
+	}
+	if cutoff, err := reopened.IssuedBefore("rider-1"); err != nil || cutoff.IsZero() {
+		t.Errorf("IssuedBefore() after rewrite = %v, %v, want a cutoff", cutoff, err)
+	}
+}
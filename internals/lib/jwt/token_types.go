@@ -0,0 +1,147 @@
+package jwt
+
+import (
+	"fmt"
+	"time"
+)
+
+// TokenType is stamped into every token minted by the typed helpers so that a token
+// issued for one purpose is never accepted for another
+type TokenType string
+
+const (
+	TokenTypeAccess        TokenType = "access"
+	TokenTypeRefresh       TokenType = "refresh"
+	TokenTypePasswordReset TokenType = "password_reset"
+	TokenTypeEmailVerify   TokenType = "email_verification"
+	TokenTypeMFAChallenge  TokenType = "mfa_challenge"
+	TokenTypeCancelDelete  TokenType = "cancel_deletion"
+	TokenTypeEmailChange   TokenType = "email_change"
+	TokenTypePartner       TokenType = "partner"
+)
+
+// known reports whether t is one of the token types above
+func (t TokenType) known() bool {
+	switch t {
+	case TokenTypeAccess, TokenTypeRefresh, TokenTypePasswordReset, TokenTypeEmailVerify,
+		TokenTypeMFAChallenge, TokenTypeCancelDelete, TokenTypeEmailChange, TokenTypePartner:
+		return true
+	}
+	return false
+}
+
+// GenerateTypedToken mints a token of the given type. Prefer the purpose-specific
+// helpers such as GenerateAccessToken.
+func GenerateTypedToken(tokenType TokenType, email string, userID string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	opts = append(opts, func(c *CustomClaims) {
+		c.TokenType = tokenType
+	})
+	return GenerateToken(email, userID, keyring, duration, opts...)
+}
+
+// VerifyTypedToken verifies a token and requires its token_type claim to be tokenType.
+// Tokens without the claim are rejected.
+func VerifyTypedToken(tokenType TokenType, tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	claims, err := VerifyToken(tokenString, keys, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("%w: want %s token", ErrWrongTokenType, tokenType)
+	}
+	return claims, nil
+}
+
+func GenerateAccessToken(email string, userID string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	return GenerateTypedToken(TokenTypeAccess, email, userID, keyring, duration, opts...)
+}
+
+func GenerateRefreshToken(email string, userID string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	return GenerateTypedToken(TokenTypeRefresh, email, userID, keyring, duration, opts...)
+}
+
+// VerifyAccessToken accepts only access tokens, so a refresh token sent as a bearer
+// credential is rejected with ErrWrongTokenType
+func VerifyAccessToken(tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	return VerifyTypedToken(TokenTypeAccess, tokenString, keys, opts...)
+}
+
+// VerifyRefreshToken accepts only refresh tokens
+func VerifyRefreshToken(tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	return VerifyTypedToken(TokenTypeRefresh, tokenString, keys, opts...)
+}
+
+// GeneratePasswordResetToken mints a reset token for an email address. Callers enforce
+// single use by revoking the token's ID once it has been redeemed.
+func GeneratePasswordResetToken(email string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	return GenerateTypedToken(TokenTypePasswordReset, email, "", keyring, duration, opts...)
+}
+
+func VerifyPasswordResetToken(tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	return VerifyTypedToken(TokenTypePasswordReset, tokenString, keys, opts...)
+}
+
+// GenerateEmailVerificationToken mints the token embedded in verification links. It is
+// bound to both the rider and the address, so changing the email invalidates old links.
+func GenerateEmailVerificationToken(email string, userID string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	return GenerateTypedToken(TokenTypeEmailVerify, email, userID, keyring, duration, opts...)
+}
+
+func VerifyEmailVerificationToken(tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	return VerifyTypedToken(TokenTypeEmailVerify, tokenString, keys, opts...)
+}
+
+// GenerateMFAChallengeToken mints the short-lived token handed out instead of session
+// cookies when a rider with two-factor authentication passes the password check
+func GenerateMFAChallengeToken(email string, userID string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	return GenerateTypedToken(TokenTypeMFAChallenge, email, userID, keyring, duration, opts...)
+}
+
+func VerifyMFAChallengeToken(tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	return VerifyTypedToken(TokenTypeMFAChallenge, tokenString, keys, opts...)
+}
+
+// GenerateCancelDeletionToken mints the token in the link that lets a rider cancel a
+// scheduled account deletion during the grace period, while all their sessions are revoked
+func GenerateCancelDeletionToken(email string, userID string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	return GenerateTypedToken(TokenTypeCancelDelete, email, userID, keyring, duration, opts...)
+}
+
+func VerifyCancelDeletionToken(tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	return VerifyTypedToken(TokenTypeCancelDelete, tokenString, keys, opts...)
+}
+
+// GenerateEmailChangeToken mints the token in the link sent to a rider's new email
+// address. email is the new address, which replaces the old one only once the link
+// is opened.
+func GenerateEmailChangeToken(newEmail string, userID string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	return GenerateTypedToken(TokenTypeEmailChange, newEmail, userID, keyring, duration, opts...)
+}
+
+func VerifyEmailChangeToken(tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	return VerifyTypedToken(TokenTypeEmailChange, tokenString, keys, opts...)
+}
+
+// GeneratePartnerToken mints the token a gateway forwards for a caller authenticated
+// with the partner API key clientID. It carries no user: services must not accept it in
+// place of an access token, and only admit it where their policy names the scopes it
+// needs.
+func GeneratePartnerToken(clientID string, keyring *Keyring, duration time.Duration, opts ...GenerateOption) (string, error) {
+	opts = append(opts, func(c *CustomClaims) {
+		c.ClientID = clientID
+	})
+	return GenerateTypedToken(TokenTypePartner, "", "", keyring, duration, opts...)
+}
+
+// VerifyPartnerToken verifies a partner token and requires its client_id claim
+func VerifyPartnerToken(tokenString string, keys KeySource, opts ...VerifyOption) (*CustomClaims, error) {
+	claims, err := VerifyTypedToken(TokenTypePartner, tokenString, keys, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ClientID == "" || claims.UserID != "" {
+		return nil, fmt.Errorf("%w: partner token needs client_id and no userId", ErrMissingClaim)
+	}
+	return claims, nil
+}
@@ -0,0 +1,205 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testKeyring(t *testing.T, secret string) *Keyring {
+	t.Helper()
+
+	keyring, err := NewSingleKeyring(secret)
+	if err != nil {
+		t.Fatalf("NewSingleKeyring() error = %v", err)
+	}
+	return keyring
+}
+
+func TestTypedTokens(t *testing.T) {
+	keyring := testKeyring(t, "test-secret-test-secret-test-secret")
+	otherKeyring := testKeyring(t, "other-secret-other-secret-other-secret")
+	revocations := NewMemoryRevocationStore()
+
+	mint := func(generate func() (string, error)) string {
+		t.Helper()
+		token, err := generate()
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		return token
+	}
+
+	access := mint(func() (string, error) {
+		return GenerateAccessToken("rider@example.com", "rider-1", keyring, time.Minute, WithSessionID("s1"))
+	})
+	refresh := mint(func() (string, error) {
+		return GenerateRefreshToken("rider@example.com", "rider-1", keyring, time.Hour)
+	})
+	reset := mint(func() (string, error) {
+		return GeneratePasswordResetToken("rider@example.com", keyring, time.Minute)
+	})
+	challenge := mint(func() (string, error) {
+		return GenerateMFAChallengeToken("rider@example.com", "rider-1", keyring, time.Minute)
+	})
+	partner := mint(func() (string, error) {
+		return GeneratePartnerToken("key-1", keyring, time.Minute, WithScopes("checkout:create"))
+	})
+	partnerWithUser := mint(func() (string, error) {
+		return GenerateTypedToken(TokenTypePartner, "", "rider-1", keyring, time.Minute, func(c *CustomClaims) {
+			c.ClientID = "key-1"
+		})
+	})
+	untyped := mint(func() (string, error) {
+		return GenerateToken("rider@example.com", "rider-1", keyring, time.Minute)
+	})
+	expired := mint(func() (string, error) {
+		return GenerateAccessToken("rider@example.com", "rider-1", keyring, -time.Minute)
+	})
+	foreign := mint(func() (string, error) {
+		return GenerateAccessToken("rider@example.com", "rider-1", otherKeyring, time.Minute)
+	})
+	revoked := mint(func() (string, error) {
+		return GenerateAccessToken("rider@example.com", "rider-1", keyring, time.Minute, WithSessionID("s2"))
+	})
+	if err := revocations.Revoke("s2", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	verifyAccess := func(token string) (*CustomClaims, error) {
+		return VerifyAccessToken(token, keyring, CheckRevocation(revocations))
+	}
+	verifyRefresh := func(token string) (*CustomClaims, error) { return VerifyRefreshToken(token, keyring) }
+	verifyReset := func(token string) (*CustomClaims, error) { return VerifyPasswordResetToken(token, keyring) }
+	verifyChallenge := func(token string) (*CustomClaims, error) { return VerifyMFAChallengeToken(token, keyring) }
+	verifyPartner := func(token string) (*CustomClaims, error) { return VerifyPartnerToken(token, keyring) }
+
+	tests := []struct {
+		name    string
+		token   string
+		verify  func(token string) (*CustomClaims, error)
+		wantErr error
+	}{
+		{name: "access as access", token: access, verify: verifyAccess},
+		{name: "refresh as refresh", token: refresh, verify: verifyRefresh},
+		{name: "reset as reset", token: reset, verify: verifyReset},
+		{name: "challenge as challenge", token: challenge, verify: verifyChallenge},
+		{name: "partner as partner", token: partner, verify: verifyPartner},
+		{name: "refresh as access", token: refresh, verify: verifyAccess, wantErr: ErrWrongTokenType},
+		{name: "challenge as access", token: challenge, verify: verifyAccess, wantErr: ErrWrongTokenType},
+		{name: "partner as access", token: partner, verify: verifyAccess, wantErr: ErrWrongTokenType},
+		{name: "access as refresh", token: access, verify: verifyRefresh, wantErr: ErrWrongTokenType},
+		{name: "access as reset", token: access, verify: verifyReset, wantErr: ErrWrongTokenType},
+		{name: "access as partner", token: access, verify: verifyPartner, wantErr: ErrWrongTokenType},
+		{name: "untyped as access", token: untyped, verify: verifyAccess, wantErr: ErrWrongTokenType},
+		{name: "partner naming a user", token: partnerWithUser, verify: verifyPartner, wantErr: ErrMissingClaim},
+		{name: "expired access", token: expired, verify: verifyAccess, wantErr: ErrExpired},
+		{name: "access from another keyring", token: foreign, verify: verifyAccess, wantErr: ErrBadSignature},
+		{name: "revoked session", token: revoked, verify: verifyAccess, wantErr: ErrTokenRevoked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.verify(tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("verify error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verify error = %v", err)
+			}
+			if claims.TokenType == "" {
+				t.Errorf("verified claims have no token_type")
+			}
+		})
+	}
+}
+
+func TestPartnerTokenClaims(t *testing.T) {
+	keyring := testKeyring(t, "test-secret-test-secret-test-secret")
+
+	token, err := GeneratePartnerToken("key-1", keyring, time.Minute, WithRoles("partner"), WithScopes("checkout:create"))
+	if err != nil {
+		t.Fatalf("GeneratePartnerToken() error = %v", err)
+	}
+	claims, err := VerifyPartnerToken(token, keyring)
+	if err != nil {
+		t.Fatalf("VerifyPartnerToken() error = %v", err)
+	}
+
+	if claims.ClientID != "key-1" || claims.UserID != "" || claims.Email != "" {
+		t.Errorf("claims = client %q, user %q, email %q; want only client key-1", claims.ClientID, claims.UserID, claims.Email)
+	}
+	if !claims.HasRole("partner") || !claims.HasScope("checkout:create") {
+		t.Errorf("claims roles %v, scopes %q, want partner and checkout:create", claims.Roles, claims.Scope)
+	}
+}
+
+func TestKeyTokenTypes(t *testing.T) {
+	_, gatewayPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatewayKey := Key{ID: "gateway", Algorithm: AlgEdDSA, PrivateKey: gatewayPrivate, TokenTypes: []TokenType{TokenTypePasswordReset}}
+	authKey := Key{ID: "auth", Algorithm: AlgHS256, Secret: "auth-secret-auth-secret-auth-secret"}
+
+	gateway, err := NewKeyring("gateway", gatewayKey, authKey)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	if _, err := GenerateAccessToken("rider@example.com", "rider-1", gateway, time.Minute); !errors.Is(err, ErrKeyNotAllowed) {
+		t.Fatalf("GenerateAccessToken() error = %v, want ErrKeyNotAllowed", err)
+	}
+	reset, err := GeneratePasswordResetToken("rider@example.com", gateway, time.Minute)
+	if err != nil {
+		t.Fatalf("GeneratePasswordResetToken() error = %v", err)
+	}
+	if _, err := VerifyPasswordResetToken(reset, gateway); err != nil {
+		t.Errorf("VerifyPasswordResetToken() error = %v", err)
+	}
+
+	// An access token signed with the gateway key outside GenerateToken
+	forged := jwt.NewWithClaims(jwt.SigningMethodEdDSA, CustomClaims{
+		UserID:    "rider-1",
+		TokenType: TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	forged.Header["kid"] = "gateway"
+	forgedToken, err := forged.SignedString(gatewayPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Services verifying through the gateway's JWKS keep the limit
+	jwks, err := json.Marshal(gateway.JWKS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := ParseJWKS(jwks)
+	if err != nil {
+		t.Fatalf("ParseJWKS() error = %v", err)
+	}
+
+	for name, keys := range map[string]KeySource{"keyring": gateway, "jwks": published} {
+		if _, err := VerifyAccessToken(forgedToken, keys); !errors.Is(err, ErrKeyNotAllowed) {
+			t.Errorf("%s: VerifyAccessToken(forged) error = %v, want ErrKeyNotAllowed", name, err)
+		}
+	}
+
+	if !gateway.CanSign(TokenTypeAccess) {
+		t.Errorf("CanSign(access) = false, want true for the unrestricted auth secret")
+	}
+	if _, err := NewKeyring("", Key{ID: "typo", Secret: "secret", TokenTypes: []TokenType{"acess"}}); err == nil {
+		t.Errorf("NewKeyring() accepted an unknown token type")
+	}
+}
@@ -0,0 +1,159 @@
+// Package mfa keeps riders' TOTP enrollments and backup codes. The auth service reads
+// the store to decide whether a login needs a second factor; the REST gateway reads and
+// writes it to enroll riders and to check the codes they enter.
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/lib/totp"
+)
+
+const (
+	// BackupCodeCount is how many backup codes are issued at a time
+	BackupCodeCount = 10
+
+	// MaxFailedAttempts is how many wrong codes a login challenge tolerates
+	MaxFailedAttempts = 5
+
+	// ChallengeTTL is how long a rider has to enter a code after the first factor
+	ChallengeTTL = 5 * time.Minute
+
+	// codeSkew accepts codes from one time step either side of now
+	codeSkew = 1
+)
+
+var backupEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrNotEnabled is returned by Spend for riders without a confirmed enrollment
+var ErrNotEnabled = errors.New("two-factor authentication is not enabled")
+
+// Challenge decides whether a login needs a second factor. The auth service calls it
+// from Login, LoginWithPhone and LoginWithOIDC once the first factor has passed. When
+// it returns a challenge token the response carries that token instead of an
+// access/refresh pair, and the rider finishes at the gateway's /api/auth/mfa/verify:
+//
+//	challenge, err := mfa.Challenge(store, keyring, user.Email, user.Id)
+//	if err != nil { ... }
+//	if challenge != "" {
+//		return &pb.LoginResponse{Success: true, Status: 200, User: user, MfaRequired: true, MfaChallenge: challenge}, nil
+//	}
+func Challenge(store Store, keyring *jwt.Keyring, email string, userID string) (string, error) {
+	enabled, err := Enabled(store, userID)
+	if err != nil || !enabled {
+		return "", err
+	}
+	return jwt.GenerateMFAChallengeToken(email, userID, keyring, ChallengeTTL)
+}
+
+// Enrollment is a rider's TOTP configuration. It is pending until Confirmed, and only
+// confirmed enrollments make login require a second factor.
+type Enrollment struct {
+	Secret         string    `json:"secret"`
+	Confirmed      bool      `json:"confirmed"`
+	BackupCodes    [][]byte  `json:"backup_codes"` // sha256 of each unused backup code
+	LastCounter    int64     `json:"last_counter"` // last accepted TOTP time step, to stop replays
+	FailedAttempts int       `json:"failed_attempts"`
+	ConfirmedAt    time.Time `json:"confirmed_at"`
+}
+
+// Enabled reports whether login for userID requires a second factor
+func Enabled(store Store, userID string) (bool, error) {
+	enrollment, ok, err := store.Get(userID)
+	if err != nil {
+		return false, err
+	}
+	return ok && enrollment.Confirmed, nil
+}
+
+// VerifyTOTP accepts a current authenticator code that has not been used before
+func (e *Enrollment) VerifyTOTP(code string, now time.Time) bool {
+	counter, ok := totp.Validate(e.Secret, code, now, codeSkew)
+	if !ok || counter <= e.LastCounter {
+		return false
+	}
+	e.LastCounter = counter
+	return true
+}
+
+// UseBackupCode accepts and consumes an unused backup code
+func (e *Enrollment) UseBackupCode(code string) bool {
+	sum := hashBackupCode(code)
+	for i, stored := range e.BackupCodes {
+		if subtle.ConstantTimeCompare(stored, sum) == 1 {
+			e.BackupCodes = append(e.BackupCodes[:i], e.BackupCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Verify accepts either an authenticator code or a backup code
+func (e *Enrollment) Verify(code string, now time.Time) bool {
+	return e.VerifyTOTP(code, now) || e.UseBackupCode(code)
+}
+
+// Spend checks an authenticator or backup code against the user's confirmed enrollment
+// and uses it up in one Store.Update, so two requests can never both accept the same
+// code. Wrong codes are counted in FailedAttempts and a right one clears them. It
+// returns the count including this attempt; the stored count starts over once it
+// reaches MaxFailedAttempts, so callers act on that threshold once.
+func Spend(store Store, userID string, code string, now time.Time) (bool, int, error) {
+	var enabled, ok bool
+	var failures int
+	found, err := store.Update(userID, func(e *Enrollment) {
+		if enabled = e.Confirmed; !enabled {
+			return
+		}
+		if ok = e.Verify(code, now); ok {
+			e.FailedAttempts = 0
+			return
+		}
+		e.FailedAttempts++
+		failures = e.FailedAttempts
+		if e.FailedAttempts >= MaxFailedAttempts {
+			e.FailedAttempts = 0
+		}
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	if !found || !enabled {
+		return false, 0, ErrNotEnabled
+	}
+	return ok, failures, nil
+}
+
+// NewBackupCodes returns BackupCodeCount fresh codes in the form xxxx-xxxx along with
+// the hashes to store. The plain codes are shown to the rider once and never stored.
+func NewBackupCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, BackupCodeCount)
+	hashes := make([][]byte, 0, BackupCodeCount)
+
+	for i := 0; i < BackupCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(backupEncoding.EncodeToString(b))
+		code := raw[:4] + "-" + raw[4:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashBackupCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashBackupCode normalises case and the separator so riders can type codes loosely
+func hashBackupCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
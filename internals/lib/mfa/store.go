@@ -0,0 +1,229 @@
+package mfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/loop/backend/rider-auth/lib/filelock"
+)
+
+// Store keeps enrollments keyed by rider ID. Enrollments must outlive the process:
+// losing one silently turns two-factor authentication off for that rider.
+type Store interface {
+	Get(userID string) (Enrollment, bool, error)
+	Put(userID string, enrollment Enrollment) error
+	Delete(userID string) error
+
+	// Update lets change modify the user's enrollment and saves the result, holding
+	// the store's lock throughout, so concurrent requests can never spend the same
+	// code or lose each other's failed attempts. It reports false without calling
+	// change when the user has no enrollment.
+	Update(userID string, change func(enrollment *Enrollment)) (bool, error)
+}
+
+type MemoryStore struct {
+	mu          sync.Mutex
+	enrollments map[string]Enrollment
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		enrollments: make(map[string]Enrollment),
+	}
+}
+
+func (s *MemoryStore) Get(userID string) (Enrollment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, ok := s.enrollments[userID]
+	if ok {
+		enrollment.BackupCodes = append([][]byte(nil), enrollment.BackupCodes...)
+	}
+	return enrollment, ok, nil
+}
+
+func (s *MemoryStore) Put(userID string, enrollment Enrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enrollments[userID] = enrollment
+	return nil
+}
+
+func (s *MemoryStore) Delete(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.enrollments, userID)
+	return nil
+}
+
+func (s *MemoryStore) Update(userID string, change func(enrollment *Enrollment)) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return updateEnrollment(s.enrollments, userID, change), nil
+}
+
+// FileStore persists enrollments as a JSON file readable only by its owner, so they
+// survive restarts. The file is re-read whenever it is replaced or modified, and
+// writers hold a lock on a ".lock" file next to it so processes sharing the file
+// never save over each other's changes.
+type FileStore struct {
+	mu          sync.Mutex
+	path        string
+	loaded      os.FileInfo // the file as last read or written
+	enrollments map[string]Enrollment
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:        path,
+		enrollments: make(map[string]Enrollment),
+	}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) Get(userID string) (Enrollment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return Enrollment{}, false, err
+	}
+	enrollment, ok := s.enrollments[userID]
+	if ok {
+		enrollment.BackupCodes = append([][]byte(nil), enrollment.BackupCodes...)
+	}
+	return enrollment, ok, nil
+}
+
+func (s *FileStore) Put(userID string, enrollment Enrollment) error {
+	return s.update(func(enrollments map[string]Enrollment) bool {
+		enrollments[userID] = enrollment
+		return true
+	})
+}
+
+func (s *FileStore) Delete(userID string) error {
+	return s.update(func(enrollments map[string]Enrollment) bool {
+		if _, ok := enrollments[userID]; !ok {
+			return false
+		}
+		delete(enrollments, userID)
+		return true
+	})
+}
+
+func (s *FileStore) Update(userID string, change func(enrollment *Enrollment)) (bool, error) {
+	var found bool
+	err := s.update(func(enrollments map[string]Enrollment) bool {
+		found = updateEnrollment(enrollments, userID, change)
+		return found
+	})
+	return found, err
+}
+
+// update applies change to the current enrollments under the file lock and saves
+// them if change reports that it modified them
+func (s *FileStore) update(change func(enrollments map[string]Enrollment) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := filelock.Lock(s.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock MFA file: %w", err)
+	}
+	defer unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if !change(s.enrollments) {
+		return nil
+	}
+	return s.save()
+}
+
+// reload reads the file if it changed since the last read. A missing file is an empty store.
+func (s *FileStore) reload() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat MFA file: %w", err)
+	}
+
+	if s.loaded != nil && os.SameFile(info, s.loaded) && info.ModTime().Equal(s.loaded.ModTime()) && info.Size() == s.loaded.Size() {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read MFA file: %w", err)
+	}
+
+	enrollments := make(map[string]Enrollment)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &enrollments); err != nil {
+			return fmt.Errorf("failed to parse MFA file: %w", err)
+		}
+	}
+
+	s.enrollments = enrollments
+	s.loaded = info
+	return nil
+}
+
+// save writes the store to a temporary file, which CreateTemp makes owner-only, and
+// renames it over the original
+func (s *FileStore) save() error {
+	data, err := json.MarshalIndent(s.enrollments, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".mfa-*")
+	if err != nil {
+		return fmt.Errorf("failed to write MFA file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write MFA file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write MFA file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write MFA file: %w", err)
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.loaded = info
+	}
+	return nil
+}
+
+// updateEnrollment runs change on a copy of the user's enrollment and stores the copy
+func updateEnrollment(enrollments map[string]Enrollment, userID string, change func(enrollment *Enrollment)) bool {
+	enrollment, ok := enrollments[userID]
+	if !ok {
+		return false
+	}
+	enrollment.BackupCodes = append([][]byte(nil), enrollment.BackupCodes...)
+	change(&enrollment)
+	enrollments[userID] = enrollment
+	return true
+}
@@ -0,0 +1,157 @@
+package mfa
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/loop/backend/rider-auth/lib/jwt"
+)
+
+func TestFileStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mfa.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	enrollment := Enrollment{
+		Secret:      "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		Confirmed:   true,
+		BackupCodes: [][]byte{[]byte("hash-1"), []byte("hash-2")},
+		LastCounter: 42,
+		ConfirmedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if err := store.Put("rider-1", enrollment); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	got, found, err := reopened.Get("rider-1")
+	if err != nil || !found {
+		t.Fatalf("Get() = %v, %v, want the enrollment", found, err)
+	}
+	if got.Secret != enrollment.Secret || !got.Confirmed || got.LastCounter != 42 || len(got.BackupCodes) != 2 || !got.ConfirmedAt.Equal(enrollment.ConfirmedAt) {
+		t.Errorf("Get() = %+v, want %+v", got, enrollment)
+	}
+
+	if err := reopened.Delete("rider-1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, found, err := store.Get("rider-1"); err != nil || found {
+		t.Errorf("Get() after Delete in another instance = %v, %v, want not found", found, err)
+	}
+}
+
+func TestSpendBackupCodeOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mfa.json")
+	first, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		stores []Store
+	}{
+		{name: "memory store", stores: []Store{NewMemoryStore()}},
+		{name: "file store shared by two instances", stores: []Store{first, second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes, hashes, err := NewBackupCodes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			enrollment := Enrollment{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", Confirmed: true, BackupCodes: hashes}
+			if err := tt.stores[0].Put("rider-1", enrollment); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			var accepted atomic.Int32
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(store Store) {
+					defer wg.Done()
+					ok, _, err := Spend(store, "rider-1", codes[0], time.Now())
+					if err != nil {
+						t.Error(err)
+					}
+					if ok {
+						accepted.Add(1)
+					}
+				}(tt.stores[i%len(tt.stores)])
+			}
+			wg.Wait()
+
+			if got := accepted.Load(); got != 1 {
+				t.Fatalf("backup code accepted %d times, want once", got)
+			}
+			got, _, err := tt.stores[0].Get("rider-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.BackupCodes) != BackupCodeCount-1 {
+				t.Errorf("%d backup codes left, want %d", len(got.BackupCodes), BackupCodeCount-1)
+			}
+		})
+	}
+}
+
+func TestSpendNotEnabled(t *testing.T) {
+	store := NewMemoryStore()
+	if err := store.Put("pending", Enrollment{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, userID := range []string{"pending", "unknown"} {
+		if _, _, err := Spend(store, userID, "123456", time.Now()); !errors.Is(err, ErrNotEnabled) {
+			t.Errorf("Spend(%q) error = %v, want ErrNotEnabled", userID, err)
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	keyring, err := jwt.NewSingleKeyring("test-secret-test-secret-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	if err := store.Put("pending", Enrollment{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("enabled", Enrollment{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", Confirmed: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, userID := range []string{"pending", "unknown"} {
+		challenge, err := Challenge(store, keyring, userID+"@example.com", userID)
+		if err != nil || challenge != "" {
+			t.Errorf("Challenge(%q) = %q, %v; want no challenge", userID, challenge, err)
+		}
+	}
+
+	challenge, err := Challenge(store, keyring, "enabled@example.com", "enabled")
+	if err != nil {
+		t.Fatalf("Challenge() error = %v", err)
+	}
+	claims, err := jwt.VerifyMFAChallengeToken(challenge, keyring)
+	if err != nil {
+		t.Fatalf("VerifyMFAChallengeToken() error = %v", err)
+	}
+	if claims.UserID != "enabled" || claims.Email != "enabled@example.com" {
+		t.Errorf("challenge claims = %q, %q; want the enabled rider", claims.UserID, claims.Email)
+	}
+}
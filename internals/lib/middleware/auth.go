@@ -2,79 +2,94 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
 
+	"github.com/loop/backend/rider-auth/lib/authn"
 	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 type contextKey string
 
-const (
-	UserIDKey contextKey = "userId"
-	EmailKey  contextKey = "email"
-)
+// AuthInterceptor verifies the bearer token on every call except Login and Register and
+// rejects tokens recorded in the revocation store. A nil store skips the revocation check.
+// opts add issuer, audience, algorithm and claim requirements. Services with other
+// public methods should build a Policy and use its UnaryInterceptor instead.
+func AuthInterceptor(keys jwtlib.KeySource, revocations jwtlib.RevocationStore, opts ...jwtlib.VerifyOption) grpc.UnaryServerInterceptor {
+	authenticator := bearerAuthenticator(keys, revocations, opts)
 
-func AuthInterceptor(secretKey string) grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
 		req interface{},
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
-		if info.FullMethod == "/rider_auth.AuthService/Login" ||
-			info.FullMethod == "/rider_auth.AuthService/Register" {
+		if isPublicMethod(info.FullMethod) {
 			return handler(ctx, req)
 		}
 
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Errorf(codes.Unauthenticated, "missing metadata")
-		}
-
-		authHeader := md.Get("authorization")
-		if len(authHeader) == 0 {
-			return nil, status.Errorf(codes.Unauthenticated, "missing authorization token")
+		ctx, _, err := authenticate(ctx, authenticator)
+		if err != nil {
+			return nil, err
 		}
 
-		// Extract token from "Bearer <token>" format
-		token := strings.TrimSpace(strings.TrimPrefix(authHeader[0], "Bearer"))
-		if token == "" {
-			return nil, status.Errorf(codes.Unauthenticated, "invalid authorization format")
-		}
+		return handler(ctx, req)
+	}
+}
 
-		// Verify token
-		claims, err := jwtlib.VerifyToken(token, secretKey)
-		if err != nil {
-			return nil, status.Errorf(codes.Unauthenticated, "invalid or expired token: %v", err)
-		}
+// isPublicMethod reports whether a method is callable without a token
+func isPublicMethod(fullMethod string) bool {
+	return fullMethod == "/rider_auth.AuthService/Login" ||
+		fullMethod == "/rider_auth.AuthService/Register"
+}
 
-		// Add claims to context
-		ctx = context.WithValue(ctx, UserIDKey, claims.UserID)
-		ctx = context.WithValue(ctx, EmailKey, claims.Email)
+// bearerAuthenticator accepts access tokens only; partner tokens are refused with
+// ErrWrongTokenType
+func bearerAuthenticator(keys jwtlib.KeySource, revocations jwtlib.RevocationStore, opts []jwtlib.VerifyOption) authn.Authenticator {
+	verifyOpts := append([]jwtlib.VerifyOption{jwtlib.CheckRevocation(revocations)}, opts...)
+	return authn.Bearer(keys, verifyOpts...)
+}
 
-		fmt.Printf("Authenticated user: %s (ID: %s)\n", claims.Email, claims.UserID)
+// policyAuthenticator also accepts partner tokens, which Rule.check only lets through
+// to methods with partner scopes
+func policyAuthenticator(keys jwtlib.KeySource, revocations jwtlib.RevocationStore, opts []jwtlib.VerifyOption) authn.Authenticator {
+	verifyOpts := append([]jwtlib.VerifyOption{jwtlib.CheckRevocation(revocations)}, opts...)
+	return authn.Chain(authn.Partner(keys, verifyOpts...), authn.Bearer(keys, verifyOpts...))
+}
 
-		return handler(ctx, req)
+// authenticate runs authenticator against the incoming call and returns ctx carrying
+// the principal. Errors are gRPC statuses ready to return to the caller.
+func authenticate(ctx context.Context, authenticator authn.Authenticator) (context.Context, *authn.Principal, error) {
+	principal, err := authenticator.Authenticate(ctx, authn.FromGRPC(ctx))
+	switch {
+	case errors.Is(err, authn.ErrNoCredentials):
+		return nil, nil, status.Errorf(codes.Unauthenticated, "missing authorization token")
+	case errors.Is(err, authn.ErrUnavailable):
+		return nil, nil, status.Errorf(codes.Unavailable, "%v", err)
+	case err != nil:
+		return nil, nil, status.Errorf(codes.Unauthenticated, "%s: %v", jwtlib.ErrorCode(err), err)
 	}
+
+	return authn.WithPrincipal(ctx, principal), principal, nil
 }
 
+// GetUserIDFromContext returns the authenticated caller's ID
+//
+// Deprecated: use authn.SubjectFromContext
 func GetUserIDFromContext(ctx context.Context) (string, error) {
-	userID, ok := ctx.Value(UserIDKey).(string)
-	if !ok || userID == "" {
-		return "", fmt.Errorf("user ID not found in context")
-	}
-	return userID, nil
+	return authn.SubjectFromContext(ctx)
 }
 
+// GetEmailFromContext returns the authenticated caller's email
+//
+// Deprecated: use authn.PrincipalFromContext
 func GetEmailFromContext(ctx context.Context) (string, error) {
-	email, ok := ctx.Value(EmailKey).(string)
-	if !ok || email == "" {
+	principal, ok := authn.PrincipalFromContext(ctx)
+	if !ok || principal.Email == "" {
 		return "", fmt.Errorf("email not found in context")
 	}
-	return email, nil
+	return principal.Email, nil
 }
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	"github.com/loop/backend/rider-auth/lib/authz"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AuthorizationInterceptor enforces per-method permissions and must be chained after
+// AuthInterceptor. policy maps a FullMethod such as "/rider_auth.AuthService/BanRider",
+// or a whole service as "/rider_auth.AdminService/*", to the roles and scopes it needs.
+// Methods not in the policy only need authentication.
+func AuthorizationInterceptor(policy map[string]authz.Requirement) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		requirement, ok := lookupRule(policy, info.FullMethod)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		principal, ok := authn.PrincipalFromContext(ctx)
+		if !ok {
+			return nil, status.Errorf(codes.Unauthenticated, "missing authorization token")
+		}
+
+		if err := authz.Check(principal, requirement); err != nil {
+			return nil, PermissionDenied(err)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// PermissionDenied converts an authz.Check error into the gRPC status returned to callers
+func PermissionDenied(err error) error {
+	var denied *authz.DeniedError
+	if errors.As(err, &denied) {
+		return status.Errorf(codes.PermissionDenied, "%s: %s", denied.Code, denied.Message)
+	}
+	return status.Errorf(codes.Internal, "authorization failed: %v", err)
+}
+
+// GetClaimsFromContext returns the claims of the token verified by AuthInterceptor
+//
+// Deprecated: use authn.PrincipalFromContext, which also covers callers authenticated
+// without a token.
+func GetClaimsFromContext(ctx context.Context) (*jwtlib.CustomClaims, bool) {
+	principal, ok := authn.PrincipalFromContext(ctx)
+	if !ok || principal.Claims == nil {
+		return nil, false
+	}
+	return principal.Claims, true
+}
+
+// lookupRule finds the entry for fullMethod, falling back to its service's "/svc/*"
+// entry. There is deliberately no catch-all: every service is named explicitly.
+func lookupRule[T any](rules map[string]T, fullMethod string) (T, bool) {
+	if rule, ok := rules[fullMethod]; ok {
+		return rule, true
+	}
+
+	var rule T
+	i := strings.LastIndex(fullMethod, "/")
+	if i <= 0 {
+		return rule, false
+	}
+	rule, ok := rules[fullMethod[:i]+"/*"]
+	return rule, ok
+}
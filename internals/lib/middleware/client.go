@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys forwarded to downstream services
+const (
+	AuthorizationMetadataKey = "authorization"
+	RequestIDMetadataKey     = "x-request-id"
+	ClientIPMetadataKey      = "x-client-ip"
+	UserAgentMetadataKey     = "x-client-user-agent"
+)
+
+const callMetadataKey contextKey = "callMetadata"
+
+// CallMetadata describes the request being served: the caller's verified credential,
+// "Bearer <token>", and details for tracing and auditing. Gateways record it in the
+// request context and the client interceptors attach it to every outgoing call.
+type CallMetadata struct {
+	Authorization string
+	RequestID     string
+	ClientIP      string
+	UserAgent     string
+}
+
+// WithCallMetadata records md in ctx for the client interceptors
+func WithCallMetadata(ctx context.Context, md CallMetadata) context.Context {
+	return context.WithValue(ctx, callMetadataKey, md)
+}
+
+// CallMetadataFromContext returns the metadata recorded by WithCallMetadata
+func CallMetadataFromContext(ctx context.Context) (CallMetadata, bool) {
+	md, ok := ctx.Value(callMetadataKey).(CallMetadata)
+	return md, ok
+}
+
+// UnaryClientInterceptor attaches the context's CallMetadata to outgoing calls. Keys
+// the caller already set on the outgoing context are left alone.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(outgoingContext(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor for streaming calls
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(outgoingContext(ctx), desc, cc, method, opts...)
+	}
+}
+
+func outgoingContext(ctx context.Context) context.Context {
+	md, ok := CallMetadataFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	existing, _ := metadata.FromOutgoingContext(ctx)
+	var pairs []string
+	for _, kv := range [][2]string{
+		{AuthorizationMetadataKey, md.Authorization},
+		{RequestIDMetadataKey, md.RequestID},
+		{ClientIPMetadataKey, md.ClientIP},
+		{UserAgentMetadataKey, md.UserAgent},
+	} {
+		if kv[1] != "" && len(existing.Get(kv[0])) == 0 {
+			pairs = append(pairs, kv[0], kv[1])
+		}
+	}
+
+	if len(pairs) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
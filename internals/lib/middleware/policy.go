@@ -0,0 +1,383 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	"github.com/loop/backend/rider-auth/lib/authz"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// Access says what a caller needs to invoke a method
+type Access int
+
+const (
+	// AccessPublic methods are callable without a token
+	AccessPublic Access = iota
+	// AccessAuthenticated methods need a valid access token
+	AccessAuthenticated
+	// AccessRestricted methods need a valid access token that also meets a Requirement
+	AccessRestricted
+	// AccessService methods need a client certificate for one of the rule's Services.
+	// They act on a rider named in the request, so no rider token is enough.
+	AccessService
+)
+
+// Rule is the policy entry for a method or service
+type Rule struct {
+	Access      Access
+	Requirement authz.Requirement
+	// PartnerScopes admits partner principals (authn.Partner) holding every one of
+	// these scopes. Partners are refused on methods without PartnerScopes, whatever
+	// their Access.
+	PartnerScopes []string
+	// Services are the certificate identities (first URI SAN, else common name) an
+	// AccessService method accepts, e.g. the gateway's SPIFFE ID
+	Services []string
+}
+
+// Policy maps full methods ("/rider_auth.AuthService/Login") or whole services
+// ("/rider_auth.AdminService/*") to a Rule. A method's own entry wins over its
+// service's. There is no catch-all entry: methods matching no entry are refused, and
+// ValidateServer reports them at startup.
+//
+//	policy := middleware.NewPolicy().
+//		Public("/rider_auth.AuthService/Login", "/rider_auth.AuthService/Register").
+//		Authenticated("/rider_auth.AuthService/*").
+//		Require(authz.Roles(authz.RoleAdmin), "/rider_auth.AdminService/*").
+//		Authenticated("/payment.PaymentService/CreateCheckOutSession").
+//		Partners([]string{"checkout:create"}, "/payment.PaymentService/CreateCheckOutSession").
+//		Services([]string{"spiffe://loop/gateway"}, "/rider_auth.AuthService/IssueTokens")
+type Policy struct {
+	rules map[string]Rule
+}
+
+func NewPolicy() *Policy {
+	return &Policy{
+		rules: make(map[string]Rule),
+	}
+}
+
+func (p *Policy) Public(methods ...string) *Policy {
+	return p.set(Rule{Access: AccessPublic}, methods)
+}
+
+func (p *Policy) Authenticated(methods ...string) *Policy {
+	return p.set(Rule{Access: AccessAuthenticated}, methods)
+}
+
+// Require restricts methods to tokens that meet requirement
+func (p *Policy) Require(requirement authz.Requirement, methods ...string) *Policy {
+	return p.set(Rule{Access: AccessRestricted, Requirement: requirement}, methods)
+}
+
+// Services restricts methods to callers presenting a client certificate for one of
+// identities. The certificate is checked even when the call also carries a token, since
+// the gateway forwards the rider's token on the same connection.
+func (p *Policy) Services(identities []string, methods ...string) *Policy {
+	return p.set(Rule{Access: AccessService, Services: identities}, methods)
+}
+
+// Partners also admits partner principals that hold every one of scopes to methods.
+// Riders keep the method's other rule, which defaults to Authenticated.
+func (p *Policy) Partners(scopes []string, methods ...string) *Policy {
+	for _, method := range methods {
+		rule, ok := p.rules[method]
+		if !ok {
+			rule = Rule{Access: AccessAuthenticated}
+		}
+		rule.PartnerScopes = scopes
+		p.rules[method] = rule
+	}
+	return p
+}
+
+// set replaces the rule for methods, keeping partner scopes already given to them
+func (p *Policy) set(rule Rule, methods []string) *Policy {
+	for _, method := range methods {
+		rule.PartnerScopes = p.rules[method].PartnerScopes
+		p.rules[method] = rule
+	}
+	return p
+}
+
+// Lookup returns the rule for a full method
+func (p *Policy) Lookup(fullMethod string) (Rule, bool) {
+	return lookupRule(p.rules, fullMethod)
+}
+
+// ValidateServer fails when server registers a method the policy does not cover, so
+// a new RPC cannot ship without a decision about who may call it. Call it after every
+// service has been registered and before Serve.
+func (p *Policy) ValidateServer(server *grpc.Server) error {
+	for method, rule := range p.rules {
+		if err := checkPolicyMethod(method); err != nil {
+			return err
+		}
+		if rule.Access == AccessService && len(rule.Services) == 0 {
+			return fmt.Errorf("auth policy: %s is service only but names no service identities", method)
+		}
+	}
+
+	var missing []string
+	for service, info := range server.GetServiceInfo() {
+		for _, method := range info.Methods {
+			fullMethod := "/" + service + "/" + method.Name
+			if _, ok := p.Lookup(fullMethod); !ok {
+				missing = append(missing, fullMethod)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("auth policy does not cover: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// UnaryInterceptor authenticates bearer tokens, and the partner tokens the gateway
+// forwards for API key callers, and authorizes unary calls according to the policy. It
+// replaces chaining AuthInterceptor and AuthorizationInterceptor.
+func (p *Policy) UnaryInterceptor(keys jwtlib.KeySource, revocations jwtlib.RevocationStore, opts ...jwtlib.VerifyOption) grpc.UnaryServerInterceptor {
+	return p.UnaryAuthInterceptor(policyAuthenticator(keys, revocations, opts))
+}
+
+// StreamInterceptor is UnaryInterceptor for streaming RPCs. Streams end with
+// Unauthenticated when their token expires, as with StreamAuthInterceptor.
+func (p *Policy) StreamInterceptor(keys jwtlib.KeySource, revocations jwtlib.RevocationStore, opts ...jwtlib.VerifyOption) grpc.StreamServerInterceptor {
+	return p.StreamAuthInterceptor(policyAuthenticator(keys, revocations, opts))
+}
+
+// UnaryAuthInterceptor is UnaryInterceptor for any authenticator, e.g.
+//
+//	policy.UnaryAuthInterceptor(authn.Chain(authn.Partner(keys), authn.Bearer(keys), authn.MTLS(authz.RoleService)))
+//
+// Keep MTLS after Partner and Bearer. The gateway presents its client certificate on every call,
+// including the ones it forwards for riders, so an MTLS-first chain would run rider
+// calls with the service role.
+func (p *Policy) UnaryAuthInterceptor(authenticator authn.Authenticator) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		rule, err := p.rule(info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Access == AccessPublic {
+			return handler(ctx, req)
+		}
+
+		ctx, principal, err := authenticate(ctx, rule.authenticator(authenticator))
+		if err != nil {
+			return nil, err
+		}
+		if err := rule.check(principal); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor is UnaryAuthInterceptor for streaming RPCs
+func (p *Policy) StreamAuthInterceptor(authenticator authn.Authenticator) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		rule, err := p.rule(info.FullMethod)
+		if err != nil {
+			return err
+		}
+		if rule.Access == AccessPublic {
+			return handler(srv, ss)
+		}
+
+		return serveAuthenticatedStream(srv, ss, handler, rule.authenticator(authenticator), rule.check)
+	}
+}
+
+// rule refuses methods the policy does not mention rather than guessing
+func (p *Policy) rule(fullMethod string) (Rule, error) {
+	rule, ok := p.Lookup(fullMethod)
+	if !ok {
+		return Rule{}, status.Errorf(codes.PermissionDenied, "method_not_allowed: %s is not in the auth policy", fullMethod)
+	}
+	return rule, nil
+}
+
+// serviceAuthenticator identifies callers of AccessService methods by their certificate
+var serviceAuthenticator = authn.MTLS(authz.RoleService)
+
+// authenticator returns the interceptor's authenticator, or serviceAuthenticator for
+// AccessService rules so a forwarded rider token cannot stand in for the certificate
+func (r Rule) authenticator(configured authn.Authenticator) authn.Authenticator {
+	if r.Access == AccessService {
+		return serviceAuthenticator
+	}
+	return configured
+}
+
+func (r Rule) check(principal *authn.Principal) error {
+	if r.Access == AccessService {
+		if principal.Method != authn.MethodMTLS || !slices.Contains(r.Services, principal.Subject) {
+			return status.Errorf(codes.PermissionDenied, "service_only: this method only accepts the services named in the auth policy")
+		}
+		return nil
+	}
+
+	// A partner acts for riders only where the policy names the scopes it needs
+	if principal.ClientID != "" {
+		if len(r.PartnerScopes) == 0 {
+			return status.Errorf(codes.PermissionDenied, "partner_not_allowed: partner credentials are not accepted for this method")
+		}
+		if err := authz.Check(principal, authz.Scopes(r.PartnerScopes...)); err != nil {
+			return PermissionDenied(err)
+		}
+		return nil
+	}
+
+	if r.Access != AccessRestricted {
+		return nil
+	}
+	if err := authz.Check(principal, r.Requirement); err != nil {
+		return PermissionDenied(err)
+	}
+	return nil
+}
+
+// policyFile is the YAML form of a Policy:
+//
+//	methods:
+//	  /rider_auth.AuthService/Login: public
+//	  /rider_auth.AuthService/*: authenticated
+//	  /rider_auth.AdminService/*:
+//	    roles: [admin]
+//	  /payment.PaymentService/CreateCheckOutSession:
+//	    partner_scopes: [checkout:create]
+//	  /rider_auth.AuthService/IssueTokens:
+//	    services: [spiffe://loop/gateway]
+//
+// A map with only partner_scopes leaves riders at authenticated. services cannot be
+// combined with the other fields.
+type policyFile struct {
+	Methods map[string]yaml.Node `yaml:"methods"`
+}
+
+type policyRequirement struct {
+	Roles         []string `yaml:"roles"`
+	Scopes        []string `yaml:"scopes"`
+	PartnerScopes []string `yaml:"partner_scopes"`
+	Services      []string `yaml:"services"`
+}
+
+// LoadPolicyFile reads a Policy from YAML
+func LoadPolicyFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth policy: %w", err)
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses the YAML accepted by LoadPolicyFile
+func ParsePolicy(data []byte) (*Policy, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var file policyFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse auth policy: %w", err)
+	}
+	if len(file.Methods) == 0 {
+		return nil, fmt.Errorf("auth policy has no methods")
+	}
+
+	policy := NewPolicy()
+	for method, node := range file.Methods {
+		if err := checkPolicyMethod(method); err != nil {
+			return nil, err
+		}
+
+		if node.Kind == yaml.ScalarNode {
+			switch node.Value {
+			case "public":
+				policy.Public(method)
+			case "authenticated":
+				policy.Authenticated(method)
+			default:
+				return nil, fmt.Errorf("auth policy: %s: unknown access %q, want public, authenticated or a roles/scopes map", method, node.Value)
+			}
+			continue
+		}
+
+		if err := checkRequirementFields(&node); err != nil {
+			return nil, fmt.Errorf("auth policy: %s: %w", method, err)
+		}
+
+		var req policyRequirement
+		if err := node.Decode(&req); err != nil {
+			return nil, fmt.Errorf("auth policy: %s: %w", method, err)
+		}
+		switch {
+		case len(req.Services) > 0:
+			if len(req.Roles) > 0 || len(req.Scopes) > 0 || len(req.PartnerScopes) > 0 {
+				return nil, fmt.Errorf("auth policy: %s: services cannot be combined with roles, scopes or partner_scopes", method)
+			}
+			policy.Services(req.Services, method)
+			continue
+		case len(req.Roles) > 0 || len(req.Scopes) > 0:
+			policy.Require(authz.Requirement{AnyRole: req.Roles, AllScopes: req.Scopes}, method)
+		case len(req.PartnerScopes) > 0:
+			policy.Authenticated(method)
+		default:
+			return nil, fmt.Errorf("auth policy: %s: give roles, scopes, partner_scopes or services, or use authenticated", method)
+		}
+		if len(req.PartnerScopes) > 0 {
+			policy.Partners(req.PartnerScopes, method)
+		}
+	}
+	return policy, nil
+}
+
+// checkPolicyMethod accepts "/pkg.Service/Method" and "/pkg.Service/*"
+func checkPolicyMethod(method string) error {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !strings.HasPrefix(method, "/") || !ok || service == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("auth policy: %q is not a full method such as /pkg.Service/Method or a service such as /pkg.Service/*", method)
+	}
+	return nil
+}
+
+// checkRequirementFields rejects keys other than roles, scopes, partner_scopes and services. Node.Decode does not
+// honour the decoder's KnownFields, so a typo such as "scope:" next to roles would
+// otherwise drop the scope requirement without an error.
+func checkRequirementFields(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		switch key := node.Content[i].Value; key {
+		case "roles", "scopes", "partner_scopes", "services":
+		default:
+			return fmt.Errorf("line %d: unknown field %q, want roles, scopes, partner_scopes or services", node.Content[i].Line, key)
+		}
+	}
+	return nil
+}
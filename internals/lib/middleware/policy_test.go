@@ -0,0 +1,421 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	"github.com/loop/backend/rider-auth/lib/authz"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+		method  string
+		want    Rule
+		found   bool
+	}{
+		{
+			name:   "public method",
+			yaml:   "methods:\n  /rider_auth.AuthService/Login: public\n",
+			method: "/rider_auth.AuthService/Login",
+			want:   Rule{Access: AccessPublic},
+			found:  true,
+		},
+		{
+			name:   "service entry covers its methods",
+			yaml:   "methods:\n  /rider_auth.AuthService/*: authenticated\n",
+			method: "/rider_auth.AuthService/GetRiderDetails",
+			want:   Rule{Access: AccessAuthenticated},
+			found:  true,
+		},
+		{
+			name: "method entry wins over its service",
+			yaml: "methods:\n" +
+				"  /rider_auth.AuthService/*: authenticated\n" +
+				"  /rider_auth.AuthService/Login: public\n",
+			method: "/rider_auth.AuthService/Login",
+			want:   Rule{Access: AccessPublic},
+			found:  true,
+		},
+		{
+			name: "roles and scopes",
+			yaml: "methods:\n" +
+				"  /payment.PaymentService/CreateCheckOutSession:\n" +
+				"    roles: [partner]\n" +
+				"    scopes: [checkout:create]\n",
+			method: "/payment.PaymentService/CreateCheckOutSession",
+			want: Rule{
+				Access:      AccessRestricted,
+				Requirement: authz.Requirement{AnyRole: []string{"partner"}, AllScopes: []string{"checkout:create"}},
+			},
+			found: true,
+		},
+		{
+			name: "partner scopes",
+			yaml: "methods:\n" +
+				"  /payment.PaymentService/CreateCheckOutSession:\n" +
+				"    partner_scopes: [checkout:create]\n",
+			method: "/payment.PaymentService/CreateCheckOutSession",
+			want:   Rule{Access: AccessAuthenticated, PartnerScopes: []string{"checkout:create"}},
+			found:  true,
+		},
+		{
+			name: "partner scopes next to roles",
+			yaml: "methods:\n" +
+				"  /rider_auth.AdminService/*:\n" +
+				"    roles: [admin]\n" +
+				"    partner_scopes: [token:introspect]\n",
+			method: "/rider_auth.AdminService/ListRiders",
+			want: Rule{
+				Access:        AccessRestricted,
+				Requirement:   authz.Requirement{AnyRole: []string{"admin"}},
+				PartnerScopes: []string{"token:introspect"},
+			},
+			found: true,
+		},
+		{
+			name: "service identities",
+			yaml: "methods:\n" +
+				"  /rider_auth.AuthService/IssueTokens:\n" +
+				"    services: [spiffe://loop/gateway]\n",
+			method: "/rider_auth.AuthService/IssueTokens",
+			want:   Rule{Access: AccessService, Services: []string{"spiffe://loop/gateway"}},
+			found:  true,
+		},
+		{
+			name: "services next to roles",
+			yaml: "methods:\n" +
+				"  /rider_auth.AuthService/IssueTokens:\n" +
+				"    services: [spiffe://loop/gateway]\n" +
+				"    roles: [admin]\n",
+			wantErr: "services cannot be combined",
+		},
+		{
+			name:   "other services are not covered",
+			yaml:   "methods:\n  /rider_auth.AuthService/*: authenticated\n",
+			method: "/payment.PaymentService/CreateCheckOutSession",
+			found:  false,
+		},
+		{
+			name:    "catch-all is rejected",
+			yaml:    "methods:\n  \"*\": authenticated\n",
+			wantErr: "is not a full method",
+		},
+		{
+			name:    "service without method is rejected",
+			yaml:    "methods:\n  /rider_auth.AuthService: authenticated\n",
+			wantErr: "is not a full method",
+		},
+		{
+			name:    "nested method is rejected",
+			yaml:    "methods:\n  /rider_auth.AuthService/Login/x: public\n",
+			wantErr: "is not a full method",
+		},
+		{
+			name:    "unknown access",
+			yaml:    "methods:\n  /rider_auth.AuthService/Login: open\n",
+			wantErr: "unknown access",
+		},
+		{
+			name:    "unknown top-level field",
+			yaml:    "method:\n  /rider_auth.AuthService/Login: public\n",
+			wantErr: "not found in type",
+		},
+		{
+			name: "unknown requirement field",
+			yaml: "methods:\n" +
+				"  /rider_auth.AdminService/*:\n" +
+				"    roles: [admin]\n" +
+				"    scope: [admin:write]\n",
+			wantErr: `unknown field "scope"`,
+		},
+		{
+			name:    "empty requirement",
+			yaml:    "methods:\n  /rider_auth.AdminService/*: {}\n",
+			wantErr: "give roles, scopes, partner_scopes or services",
+		},
+		{
+			name:    "no methods",
+			yaml:    "methods: {}\n",
+			wantErr: "no methods",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParsePolicy([]byte(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParsePolicy() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePolicy() error = %v", err)
+			}
+
+			rule, found := policy.Lookup(tt.method)
+			if found != tt.found {
+				t.Fatalf("Lookup(%q) found = %v, want %v", tt.method, found, tt.found)
+			}
+			if !equalRule(rule, tt.want) {
+				t.Errorf("Lookup(%q) = %+v, want %+v", tt.method, rule, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyValidateServer(t *testing.T) {
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "rider_auth.AuthService",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "Login", Handler: noopHandler},
+			{MethodName: "GetRiderDetails", Handler: noopHandler},
+		},
+	}, struct{}{})
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		wantErr string
+	}{
+		{
+			name:   "every method covered",
+			policy: NewPolicy().Public("/rider_auth.AuthService/Login").Authenticated("/rider_auth.AuthService/GetRiderDetails"),
+		},
+		{
+			name:   "covered by service entry",
+			policy: NewPolicy().Authenticated("/rider_auth.AuthService/*"),
+		},
+		{
+			name:    "uncovered method",
+			policy:  NewPolicy().Public("/rider_auth.AuthService/Login"),
+			wantErr: "does not cover: /rider_auth.AuthService/GetRiderDetails",
+		},
+		{
+			name:    "catch-all entry",
+			policy:  NewPolicy().Authenticated("*"),
+			wantErr: `"*" is not a full method`,
+		},
+		{
+			name:    "service rule without identities",
+			policy:  NewPolicy().Public("/rider_auth.AuthService/Login").Services(nil, "/rider_auth.AuthService/GetRiderDetails"),
+			wantErr: "names no service identities",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.ValidateServer(server)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateServer() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateServer() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRuleCheck(t *testing.T) {
+	rider := &authn.Principal{Subject: "rider-1", Roles: []string{authz.RoleRider}, Method: authn.MethodBearer}
+	partner := &authn.Principal{ClientID: "key-1", Roles: []string{authz.RolePartner}, Scopes: []string{"checkout:create"}, Method: authn.MethodAPIKey}
+	partnerWithoutScope := &authn.Principal{ClientID: "key-2", Roles: []string{authz.RolePartner}, Method: authn.MethodAPIKey}
+	gateway := &authn.Principal{Subject: "spiffe://loop/gateway", Roles: []string{authz.RoleService}, Method: authn.MethodMTLS}
+	otherService := &authn.Principal{Subject: "spiffe://loop/payment", Roles: []string{authz.RoleService}, Method: authn.MethodMTLS}
+	admin := &authn.Principal{Subject: "spiffe://loop/gateway", Roles: []string{authz.RoleAdmin, authz.RoleService}, Method: authn.MethodBearer}
+
+	issueTokens := NewPolicy().Services([]string{"spiffe://loop/gateway"}, "/rider_auth.AuthService/IssueTokens")
+
+	checkout := NewPolicy().
+		Authenticated("/payment.PaymentService/CreateCheckOutSession").
+		Partners([]string{"checkout:create"}, "/payment.PaymentService/CreateCheckOutSession")
+
+	tests := []struct {
+		name      string
+		policy    *Policy
+		method    string
+		principal *authn.Principal
+		wantCode  codes.Code
+	}{
+		{
+			name:      "rider on an authenticated method",
+			policy:    NewPolicy().Authenticated("/rider_auth.AuthService/*"),
+			method:    "/rider_auth.AuthService/GetRiderDetails",
+			principal: rider,
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "partner on an authenticated method",
+			policy:    NewPolicy().Authenticated("/rider_auth.AuthService/*"),
+			method:    "/rider_auth.AuthService/GetRiderDetails",
+			principal: partner,
+			wantCode:  codes.PermissionDenied,
+		},
+		{
+			name:      "partner with the role a method requires",
+			policy:    NewPolicy().Require(authz.Roles(authz.RolePartner), "/payment.PaymentService/ListCheckoutSessions"),
+			method:    "/payment.PaymentService/ListCheckoutSessions",
+			principal: partner,
+			wantCode:  codes.PermissionDenied,
+		},
+		{
+			name:      "partner with the partner scope",
+			policy:    checkout,
+			method:    "/payment.PaymentService/CreateCheckOutSession",
+			principal: partner,
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "partner without the partner scope",
+			policy:    checkout,
+			method:    "/payment.PaymentService/CreateCheckOutSession",
+			principal: partnerWithoutScope,
+			wantCode:  codes.PermissionDenied,
+		},
+		{
+			name:      "rider on a method open to partners",
+			policy:    checkout,
+			method:    "/payment.PaymentService/CreateCheckOutSession",
+			principal: rider,
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "partner scopes survive a later rule for the method",
+			policy:    NewPolicy().Partners([]string{"checkout:create"}, "/payment.PaymentService/CreateCheckOutSession").Authenticated("/payment.PaymentService/CreateCheckOutSession"),
+			method:    "/payment.PaymentService/CreateCheckOutSession",
+			principal: partner,
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "named service on a service method",
+			policy:    issueTokens,
+			method:    "/rider_auth.AuthService/IssueTokens",
+			principal: gateway,
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "other service on a service method",
+			policy:    issueTokens,
+			method:    "/rider_auth.AuthService/IssueTokens",
+			principal: otherService,
+			wantCode:  codes.PermissionDenied,
+		},
+		{
+			name:      "token naming the service identity",
+			policy:    issueTokens,
+			method:    "/rider_auth.AuthService/IssueTokens",
+			principal: admin,
+			wantCode:  codes.PermissionDenied,
+		},
+		{
+			name:      "rider on a service method",
+			policy:    issueTokens,
+			method:    "/rider_auth.AuthService/IssueTokens",
+			principal: rider,
+			wantCode:  codes.PermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok := tt.policy.Lookup(tt.method)
+			if !ok {
+				t.Fatalf("Lookup(%q) found no rule", tt.method)
+			}
+			if code := status.Code(rule.check(tt.principal)); code != tt.wantCode {
+				t.Fatalf("check() code = %v, want %v", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// TestServiceRuleUsesCertificate checks that a service method looks at the peer
+// certificate even when the call also forwards a rider's token
+func TestServiceRuleUsesCertificate(t *testing.T) {
+	keyring, err := jwtlib.NewSingleKeyring("test-secret-test-secret-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	riderToken, err := jwtlib.GenerateAccessToken("rider@example.com", "rider-1", keyring, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const method = "/rider_auth.AuthService/IssueTokens"
+	policy := NewPolicy().Services([]string{"spiffe://loop/gateway"}, method)
+	interceptor := policy.UnaryInterceptor(keyring, jwtlib.NewMemoryRevocationStore())
+
+	tests := []struct {
+		name     string
+		identity string
+		token    string
+		wantCode codes.Code
+	}{
+		{name: "gateway certificate", identity: "spiffe://loop/gateway", wantCode: codes.OK},
+		{name: "gateway certificate forwarding a rider", identity: "spiffe://loop/gateway", token: riderToken, wantCode: codes.OK},
+		{name: "other certificate", identity: "spiffe://loop/payment", wantCode: codes.PermissionDenied},
+		{name: "rider token without a certificate", token: riderToken, wantCode: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(AuthorizationMetadataKey, "Bearer "+tt.token))
+			}
+			if tt.identity != "" {
+				id, _ := url.Parse(tt.identity)
+				cert := &x509.Certificate{URIs: []*url.URL{id}}
+				ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{
+					State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+				}})
+			}
+
+			var principal *authn.Principal
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req any) (any, error) {
+				principal, _ = authn.PrincipalFromContext(ctx)
+				return nil, nil
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+			if err == nil && principal.Subject != tt.identity {
+				t.Errorf("principal subject = %q, want %q", principal.Subject, tt.identity)
+			}
+		})
+	}
+}
+
+func noopHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	return nil, nil
+}
+
+func equalRule(a, b Rule) bool {
+	return a.Access == b.Access &&
+		slices.Equal(a.Requirement.AnyRole, b.Requirement.AnyRole) &&
+		slices.Equal(a.Requirement.AllScopes, b.Requirement.AllScopes) &&
+		slices.Equal(a.PartnerScopes, b.PartnerScopes) &&
+		slices.Equal(a.Services, b.Services)
+}
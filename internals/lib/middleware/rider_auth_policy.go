@@ -0,0 +1,49 @@
+package middleware
+
+const riderAuthService = "/rider_auth.AuthService/"
+
+// RiderAuthPolicy is the auth policy of rider_auth.AuthService. gateway is the identity
+// in the REST gateway's client certificate, its first URI SAN or else its common name.
+// Every method has its own rule so a new RPC is refused until it is added here:
+//
+//	policy := middleware.RiderAuthPolicy("spiffe://loop/gateway")
+//	server := grpc.NewServer(
+//		grpc.Creds(serverCreds), // mtls.ServerCredentials with CAFile set
+//		grpc.UnaryInterceptor(policy.UnaryInterceptor(keys, revocations, verifyOpts...)),
+//	)
+//	// register the service, then
+//	if err := policy.ValidateServer(server); err != nil { ... }
+func RiderAuthPolicy(gateway string) *Policy {
+	return NewPolicy().
+		Public(
+			riderAuthService+"Register",
+			riderAuthService+"Login",
+			riderAuthService+"RefreshToken",
+		).
+		// These take the rider from the access token
+		Authenticated(
+			riderAuthService+"GetRiderDetails",
+			riderAuthService+"UpdateRider",
+			riderAuthService+"ChangePassword",
+			riderAuthService+"ScheduleRiderDeletion",
+			riderAuthService+"VerifyPassword",
+		).
+		// These act on the rider named in the request, after the gateway has checked a
+		// link or code the rider received
+		Services([]string{gateway},
+			// the email comes from a password reset token
+			riderAuthService+"ResetPassword",
+			// the rider and email come from an email verification token
+			riderAuthService+"VerifyEmail",
+			// the phone number was confirmed with a texted code
+			riderAuthService+"LoginWithPhone",
+			// the subject and email come from a verified ID token
+			riderAuthService+"LoginWithOIDC",
+			// the rider comes from a cancel-deletion token
+			riderAuthService+"CancelRiderDeletion",
+			// the rider and new email come from an email change token
+			riderAuthService+"ConfirmEmailChange",
+			// the rider passed the MFA challenge, or just changed their password
+			riderAuthService+"IssueTokens",
+		)
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRiderAuthPolicy(t *testing.T) {
+	const gateway = "spiffe://loop/gateway"
+	policy := RiderAuthPolicy(gateway)
+
+	tests := []struct {
+		method string
+		want   Access
+	}{
+		{method: "Login", want: AccessPublic},
+		{method: "Register", want: AccessPublic},
+		{method: "RefreshToken", want: AccessPublic},
+		{method: "GetRiderDetails", want: AccessAuthenticated},
+		{method: "UpdateRider", want: AccessAuthenticated},
+		{method: "ChangePassword", want: AccessAuthenticated},
+		{method: "ScheduleRiderDeletion", want: AccessAuthenticated},
+		{method: "VerifyPassword", want: AccessAuthenticated},
+		{method: "ResetPassword", want: AccessService},
+		{method: "VerifyEmail", want: AccessService},
+		{method: "LoginWithPhone", want: AccessService},
+		{method: "LoginWithOIDC", want: AccessService},
+		{method: "CancelRiderDeletion", want: AccessService},
+		{method: "ConfirmEmailChange", want: AccessService},
+		{method: "IssueTokens", want: AccessService},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rule, ok := policy.Lookup(riderAuthService + tt.method)
+			if !ok {
+				t.Fatalf("no rule for %s", tt.method)
+			}
+			if rule.Access != tt.want {
+				t.Fatalf("access = %v, want %v", rule.Access, tt.want)
+			}
+			if rule.Access == AccessService && !slices.Equal(rule.Services, []string{gateway}) {
+				t.Errorf("services = %v, want only the gateway", rule.Services)
+			}
+		})
+	}
+
+	if _, ok := policy.Lookup(riderAuthService + "DeleteEverything"); ok {
+		t.Errorf("unlisted method has a rule")
+	}
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	"github.com/loop/backend/rider-auth/lib/authz"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errStreamTokenExpired is the cancellation cause of a stream whose token expired
+var errStreamTokenExpired = errors.New("token expired during stream")
+
+// StreamAuthInterceptor is AuthInterceptor for streaming RPCs such as live ride status.
+// The stream's Context carries the claims. Its context is cancelled when the token
+// expires; from then on SendMsg and RecvMsg fail and the stream ends with
+// Unauthenticated, so clients must reconnect with a fresh token.
+//
+// The interceptor returns at expiry without waiting for the handler, so grpc-go ends
+// the stream even while the handler is blocked in RecvMsg; that RecvMsg then fails
+// and the handler returns in the background.
+func StreamAuthInterceptor(keys jwtlib.KeySource, revocations jwtlib.RevocationStore, opts ...jwtlib.VerifyOption) grpc.StreamServerInterceptor {
+	authenticator := bearerAuthenticator(keys, revocations, opts)
+
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if isPublicMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		return serveAuthenticatedStream(srv, ss, handler, authenticator, func(*authn.Principal) error { return nil })
+	}
+}
+
+// serveAuthenticatedStream authenticates the stream, applies check to the principal
+// and runs handler until it returns or the principal's credential expires
+func serveAuthenticatedStream(srv interface{}, ss grpc.ServerStream, handler grpc.StreamHandler, authenticator authn.Authenticator, check func(*authn.Principal) error) error {
+	ctx, principal, err := authenticate(ss.Context(), authenticator)
+	if err != nil {
+		return err
+	}
+	if err := check(principal); err != nil {
+		return err
+	}
+
+	if principal.ExpiresAt.IsZero() {
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+
+	ctx, cancel := context.WithDeadlineCause(ctx, principal.ExpiresAt, errStreamTokenExpired)
+	defer cancel()
+
+	// The handler runs in its own goroutine so that expiry can end the stream while it
+	// is blocked. A panic is passed back and raised here, where recovery interceptors
+	// chained before this one can catch it.
+	type result struct {
+		err      error
+		panicked interface{}
+	}
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		defer func() {
+			res.panicked = recover()
+			done <- res
+		}()
+		res.err = handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}()
+
+	select {
+	case res := <-done:
+		if res.panicked != nil {
+			panic(res.panicked)
+		}
+		if tokenExpired(ctx) {
+			return streamExpired()
+		}
+		return res.err
+	case <-ctx.Done():
+		if tokenExpired(ctx) {
+			return streamExpired()
+		}
+		// The client went away; the handler sees the same cancellation
+		res := <-done
+		if res.panicked != nil {
+			panic(res.panicked)
+		}
+		return res.err
+	}
+}
+
+// StreamAuthorizationInterceptor is AuthorizationInterceptor for streaming RPCs and
+// must be chained after StreamAuthInterceptor
+func StreamAuthorizationInterceptor(policy map[string]authz.Requirement) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		requirement, ok := lookupRule(policy, info.FullMethod)
+		if !ok {
+			return handler(srv, ss)
+		}
+
+		principal, ok := authn.PrincipalFromContext(ss.Context())
+		if !ok {
+			return status.Errorf(codes.Unauthenticated, "missing authorization token")
+		}
+
+		if err := authz.Check(principal, requirement); err != nil {
+			return PermissionDenied(err)
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// authStream overrides Context so handlers see the claims and the expiry deadline
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authStream) SendMsg(m interface{}) error {
+	if tokenExpired(s.ctx) {
+		return streamExpired()
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// RecvMsg cannot end a receive in progress, but never hands over a message that
+// arrived after expiry
+func (s *authStream) RecvMsg(m interface{}) error {
+	if tokenExpired(s.ctx) {
+		return streamExpired()
+	}
+	err := s.ServerStream.RecvMsg(m)
+	if tokenExpired(s.ctx) {
+		return streamExpired()
+	}
+	return err
+}
+
+// streamExpired carries the same token_expired code the unary interceptor returns
+func streamExpired() error {
+	return status.Errorf(codes.Unauthenticated, "%s: %v", jwtlib.ErrorCode(jwtlib.ErrExpired), errStreamTokenExpired)
+}
+
+func tokenExpired(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errStreamTokenExpired)
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recvStream is a ServerStream whose RecvMsg waits for the test to release it
+type recvStream struct {
+	grpc.ServerStream
+	release chan struct{}
+}
+
+func (s *recvStream) RecvMsg(m interface{}) error {
+	<-s.release
+	return nil
+}
+
+func (s *recvStream) SendMsg(m interface{}) error {
+	return nil
+}
+
+func (s *recvStream) Context() context.Context {
+	return context.Background()
+}
+
+func TestAuthStreamExpiry(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(s *authStream) error
+		// expireDuring expires the token while the call is blocked rather than before it
+		expireDuring bool
+	}{
+		{
+			name: "send after expiry",
+			call: func(s *authStream) error { return s.SendMsg(nil) },
+		},
+		{
+			name: "receive after expiry",
+			call: func(s *authStream) error { return s.RecvMsg(nil) },
+		},
+		{
+			name:         "message arriving after expiry",
+			call:         func(s *authStream) error { return s.RecvMsg(nil) },
+			expireDuring: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+
+			ss := &recvStream{release: make(chan struct{})}
+			stream := &authStream{ServerStream: ss, ctx: ctx}
+
+			if !tt.expireDuring {
+				cancel(errStreamTokenExpired)
+				close(ss.release)
+			} else {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					cancel(errStreamTokenExpired)
+					close(ss.release)
+				}()
+			}
+
+			err := tt.call(stream)
+			if status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("got %v, want Unauthenticated", err)
+			}
+		})
+	}
+}
+
+func TestAuthStreamEndsBlockedHandlerAtExpiry(t *testing.T) {
+	authenticator := authn.AuthenticatorFunc(func(ctx context.Context, req authn.Request) (*authn.Principal, error) {
+		return &authn.Principal{Subject: "rider-1", ExpiresAt: time.Now().Add(20 * time.Millisecond)}, nil
+	})
+	ss := &recvStream{release: make(chan struct{})}
+	defer close(ss.release)
+
+	// The handler waits on the client, which never sends
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		return stream.RecvMsg(nil)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serveAuthenticatedStream(nil, ss, handler, authenticator, func(*authn.Principal) error { return nil })
+	}()
+
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("got %v, want Unauthenticated", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream stayed open after the token expired")
+	}
+}
@@ -0,0 +1,62 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 appendix B SHA-1 key "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; authenticator apps use their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d) error = %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name        string
+		code        string
+		skew        int
+		wantCounter int64
+		wantOK      bool
+	}{
+		{name: "current step", code: "050471", skew: 1, wantCounter: Counter(at), wantOK: true},
+		{name: "with spaces", code: " 050471 ", skew: 1, wantCounter: Counter(at), wantOK: true},
+		{name: "previous step within skew", code: "081804", skew: 1, wantCounter: Counter(at) - 1, wantOK: true},
+		{name: "previous step without skew", code: "081804", skew: 0},
+		{name: "wrong code", code: "123456", skew: 1},
+		{name: "wrong length", code: "14050471", skew: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := Validate(rfcSecret, tt.code, at, tt.skew)
+			if ok != tt.wantOK || counter != tt.wantCounter {
+				t.Fatalf("Validate() = %d, %v, want %d, %v", counter, ok, tt.wantCounter, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package lockout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxFailuresPerEmail = 5
+	defaultMaxFailuresPerIP    = 20
+	defaultFailureWindow       = time.Hour
+	defaultBaseDelay           = time.Second
+	defaultMaxDelay            = 30 * time.Second
+	defaultLockout             = 15 * time.Minute
+	defaultMaxLockout          = 24 * time.Hour
+)
+
+var ErrLocked = errors.New("too many failed login attempts")
+
+// LockedError is returned by Reserve while a key is delayed or locked out
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", ErrLocked, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Config tunes a Guard. Zero fields take the defaults.
+type Config struct {
+	MaxFailuresPerEmail int
+	MaxFailuresPerIP    int
+	FailureWindow       time.Duration
+	Lockout             time.Duration
+}
+
+// ConfigFromEnv reads LOGIN_MAX_FAILURES_PER_EMAIL, LOGIN_MAX_FAILURES_PER_IP,
+// LOGIN_FAILURE_WINDOW and LOGIN_LOCKOUT (Go durations)
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	var err error
+
+	if cfg.MaxFailuresPerEmail, err = intFromEnv("LOGIN_MAX_FAILURES_PER_EMAIL"); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxFailuresPerIP, err = intFromEnv("LOGIN_MAX_FAILURES_PER_IP"); err != nil {
+		return Config{}, err
+	}
+	if cfg.FailureWindow, err = durationFromEnv("LOGIN_FAILURE_WINDOW"); err != nil {
+		return Config{}, err
+	}
+	if cfg.Lockout, err = durationFromEnv("LOGIN_LOCKOUT"); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// Guard throttles password guessing per email and per client IP. Each failure for an
+// email makes its next attempt wait longer (1s, 2s, 4s, ... up to 30s). Reaching the
+// threshold locks the email or IP out, and every further threshold's worth of failures
+// doubles the lockout. IPs get no per-failure delay since many riders can share one.
+type Guard struct {
+	store               Store
+	maxFailuresPerEmail int
+	maxFailuresPerIP    int
+	failureWindow       time.Duration
+	baseDelay           time.Duration
+	maxDelay            time.Duration
+	lockout             time.Duration
+	maxLockout          time.Duration
+}
+
+func NewGuard(store Store, cfg Config) *Guard {
+	g := &Guard{
+		store:               store,
+		maxFailuresPerEmail: defaultMaxFailuresPerEmail,
+		maxFailuresPerIP:    defaultMaxFailuresPerIP,
+		failureWindow:       defaultFailureWindow,
+		baseDelay:           defaultBaseDelay,
+		maxDelay:            defaultMaxDelay,
+		lockout:             defaultLockout,
+		maxLockout:          defaultMaxLockout,
+	}
+
+	if cfg.MaxFailuresPerEmail > 0 {
+		g.maxFailuresPerEmail = cfg.MaxFailuresPerEmail
+	}
+	if cfg.MaxFailuresPerIP > 0 {
+		g.maxFailuresPerIP = cfg.MaxFailuresPerIP
+	}
+	if cfg.FailureWindow > 0 {
+		g.failureWindow = cfg.FailureWindow
+	}
+	if cfg.Lockout > 0 {
+		g.lockout = cfg.Lockout
+	}
+	if g.maxLockout < g.lockout {
+		g.maxLockout = g.lockout
+	}
+
+	return g
+}
+
+// Reserve counts a password attempt for the email and IP before the password is
+// checked, or returns a *LockedError while either must wait. Counting up front means
+// concurrent attempts cannot all pass a check made before any of them failed. Each
+// reservation ends with Succeed, with Release, or stays counted as a failure. An
+// empty email or IP is skipped.
+func (g *Guard) Reserve(email, ip string, now time.Time) error {
+	limits := g.limits(email, ip)
+	for i, limit := range limits {
+		if err := g.reserve(limit, now); err != nil {
+			for _, reserved := range limits[:i] {
+				if releaseErr := g.release(reserved); releaseErr != nil {
+					return errors.Join(err, releaseErr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Succeed ends a reservation whose password was right. It clears the email's
+// failures and gives back the IP's attempt; the IP's other failures stay, so one
+// successful login cannot hide a stream of guesses at other accounts.
+func (g *Guard) Succeed(email, ip string) error {
+	for _, limit := range g.limits(email, ip) {
+		var err error
+		if limit.perFailureDelay {
+			err = g.store.Reset(limit.key)
+		} else {
+			err = g.release(limit)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release gives back a reservation whose password was never checked, e.g. because
+// the auth service failed
+func (g *Guard) Release(email, ip string) error {
+	for _, limit := range g.limits(email, ip) {
+		if err := g.release(limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reserve counts one attempt against limit unless the key is locked or, for emails,
+// still within the delay after its last failure
+func (g *Guard) reserve(limit limit, now time.Time) error {
+	for {
+		record, err := g.store.Get(limit.key)
+		if err != nil {
+			return err
+		}
+
+		if wait := g.wait(limit, record, now); wait > 0 {
+			return &LockedError{RetryAfter: wait}
+		}
+
+		next := record
+		if now.Sub(next.LastFailure) > g.failureWindow {
+			next.Failures = 0
+		}
+		next.Failures++
+		next.LastFailure = now
+		if next.Failures%limit.threshold == 0 {
+			next.LockedUntil = now.Add(g.lockoutFor(next.Failures / limit.threshold))
+		}
+		next.ExpiresAt = now.Add(g.failureWindow)
+		if next.LockedUntil.After(next.ExpiresAt) {
+			next.ExpiresAt = next.LockedUntil
+		}
+
+		swapped, err := g.store.CompareAndSwap(limit.key, record, next)
+		if err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// release takes back one counted attempt, along with the lockout it triggered
+func (g *Guard) release(limit limit) error {
+	for {
+		record, err := g.store.Get(limit.key)
+		if err != nil {
+			return err
+		}
+		if record.Failures == 0 {
+			return nil
+		}
+
+		next := record
+		if next.Failures%limit.threshold == 0 {
+			next.LockedUntil = time.Time{}
+		}
+		next.Failures--
+
+		swapped, err := g.store.CompareAndSwap(limit.key, record, next)
+		if err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// wait is how long the key must wait before its next attempt
+func (g *Guard) wait(limit limit, record Record, now time.Time) time.Duration {
+	var wait time.Duration
+	if now.Before(record.LockedUntil) {
+		wait = record.LockedUntil.Sub(now)
+	}
+	if limit.perFailureDelay && record.Failures > 0 && now.Sub(record.LastFailure) <= g.failureWindow {
+		if next := record.LastFailure.Add(g.delay(record.Failures)); now.Before(next) {
+			wait = max(wait, next.Sub(now))
+		}
+	}
+	return wait
+}
+
+func (g *Guard) delay(failures int) time.Duration {
+	d := g.baseDelay
+	for i := 1; i < failures && d < g.maxDelay; i++ {
+		d *= 2
+	}
+	return min(d, g.maxDelay)
+}
+
+func (g *Guard) lockoutFor(lockouts int) time.Duration {
+	d := g.lockout
+	for i := 1; i < lockouts && d < g.maxLockout; i++ {
+		d *= 2
+	}
+	return min(d, g.maxLockout)
+}
+
+// limit is one key the Guard counts attempts against
+type limit struct {
+	key             string
+	threshold       int
+	perFailureDelay bool
+}
+
+// limits returns the email's limit first, then the IP's
+func (g *Guard) limits(email, ip string) []limit {
+	var limits []limit
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		limits = append(limits, limit{key: "email:" + email, threshold: g.maxFailuresPerEmail, perFailureDelay: true})
+	}
+	if ip != "" {
+		limits = append(limits, limit{key: "ip:" + ip, threshold: g.maxFailuresPerIP})
+	}
+	return limits
+}
+
+func intFromEnv(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
+
+func durationFromEnv(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
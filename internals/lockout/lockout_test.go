@@ -0,0 +1,190 @@
+package lockout
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testEmail = "rider@example.com"
+	testIP    = "203.0.113.7"
+)
+
+// step is one call against the Guard, made offset after the test's start
+type step struct {
+	offset time.Duration
+	op     string // reserve, succeed or release
+	email  string
+	ip     string
+	// wantLocked is the Retry-After a reserve must answer, or zero for success
+	wantLocked time.Duration
+}
+
+func TestGuard(t *testing.T) {
+	cfg := Config{MaxFailuresPerEmail: 3, MaxFailuresPerIP: 5, FailureWindow: time.Hour, Lockout: 10 * time.Minute}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "failures delay the next attempt for the email",
+			steps: []step{
+				{op: "reserve", email: testEmail, ip: testIP},
+				{offset: 500 * time.Millisecond, op: "reserve", email: testEmail, ip: testIP, wantLocked: 500 * time.Millisecond},
+				{offset: time.Second, op: "reserve", email: testEmail, ip: testIP},
+				{offset: 2 * time.Second, op: "reserve", email: testEmail, ip: testIP, wantLocked: time.Second},
+				{offset: 3 * time.Second, op: "reserve", email: testEmail, ip: testIP},
+				{offset: 4 * time.Second, op: "reserve", email: testEmail, ip: testIP, wantLocked: 10*time.Minute - time.Second},
+			},
+		},
+		{
+			name: "lockout ends",
+			steps: []step{
+				{op: "reserve", email: testEmail, ip: testIP},
+				{offset: time.Second, op: "reserve", email: testEmail, ip: testIP},
+				{offset: 3 * time.Second, op: "reserve", email: testEmail, ip: testIP},
+				{offset: 3*time.Second + 10*time.Minute, op: "reserve", email: testEmail, ip: testIP},
+			},
+		},
+		{
+			name: "success clears the email",
+			steps: []step{
+				{op: "reserve", email: testEmail, ip: testIP},
+				{op: "succeed", email: testEmail, ip: testIP},
+				{op: "reserve", email: testEmail, ip: testIP},
+			},
+		},
+		{
+			name: "released attempts do not count",
+			steps: []step{
+				{op: "reserve", email: testEmail, ip: testIP},
+				{op: "release", email: testEmail, ip: testIP},
+				{op: "reserve", email: testEmail, ip: testIP},
+				{op: "release", email: testEmail, ip: testIP},
+				{op: "reserve", email: testEmail, ip: testIP},
+				{op: "release", email: testEmail, ip: testIP},
+				{op: "reserve", email: testEmail, ip: testIP},
+			},
+		},
+		{
+			name: "releasing the locking attempt lifts the lockout",
+			steps: []step{
+				{op: "reserve", email: testEmail, ip: testIP},
+				{offset: time.Second, op: "reserve", email: testEmail, ip: testIP},
+				{offset: 3 * time.Second, op: "reserve", email: testEmail, ip: testIP},
+				{offset: 3 * time.Second, op: "release", email: testEmail, ip: testIP},
+				{offset: 5 * time.Second, op: "reserve", email: testEmail, ip: testIP},
+			},
+		},
+		{
+			name: "IP locks out across emails",
+			steps: []step{
+				{op: "reserve", email: "a@example.com", ip: testIP},
+				{op: "reserve", email: "b@example.com", ip: testIP},
+				{op: "reserve", email: "c@example.com", ip: testIP},
+				{op: "reserve", email: "d@example.com", ip: testIP},
+				{op: "reserve", email: "e@example.com", ip: testIP},
+				{op: "reserve", email: "f@example.com", ip: testIP, wantLocked: 10 * time.Minute},
+			},
+		},
+		{
+			name: "success keeps the IP's other failures",
+			steps: []step{
+				{op: "reserve", email: "a@example.com", ip: testIP},
+				{op: "reserve", email: "b@example.com", ip: testIP},
+				{op: "reserve", email: "c@example.com", ip: testIP},
+				{op: "reserve", email: "d@example.com", ip: testIP},
+				{op: "reserve", email: testEmail, ip: testIP},
+				{op: "succeed", email: testEmail, ip: testIP},
+				{op: "reserve", email: "e@example.com", ip: testIP},
+				{op: "reserve", email: "f@example.com", ip: testIP, wantLocked: 10 * time.Minute},
+			},
+		},
+		{
+			name: "refused email does not count against the IP",
+			steps: []step{
+				{op: "reserve", email: testEmail, ip: testIP},
+				{op: "reserve", email: testEmail, ip: testIP, wantLocked: time.Second},
+				{op: "reserve", email: testEmail, ip: testIP, wantLocked: time.Second},
+				{op: "reserve", email: "b@example.com", ip: testIP},
+				{op: "reserve", email: "c@example.com", ip: testIP},
+				{op: "reserve", email: "d@example.com", ip: testIP},
+				{op: "reserve", email: "e@example.com", ip: testIP},
+				{op: "reserve", email: "f@example.com", ip: testIP, wantLocked: 10 * time.Minute},
+			},
+		},
+		{
+			name: "email is case insensitive",
+			steps: []step{
+				{op: "reserve", email: testEmail, ip: testIP},
+				{op: "reserve", email: " Rider@Example.com", ip: "198.51.100.1", wantLocked: time.Second},
+			},
+		},
+		{
+			name: "failures leave the window",
+			steps: []step{
+				{op: "reserve", email: testEmail, ip: testIP},
+				{offset: time.Second, op: "reserve", email: testEmail, ip: testIP},
+				{offset: 2*time.Hour + time.Second, op: "reserve", email: testEmail, ip: testIP},
+				{offset: 2*time.Hour + 2*time.Second, op: "reserve", email: testEmail, ip: testIP},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := NewGuard(NewMemoryStore(), cfg)
+			start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+			for i, s := range tt.steps {
+				var err error
+				switch s.op {
+				case "reserve":
+					err = guard.Reserve(s.email, s.ip, start.Add(s.offset))
+				case "succeed":
+					err = guard.Succeed(s.email, s.ip)
+				case "release":
+					err = guard.Release(s.email, s.ip)
+				}
+
+				var locked *LockedError
+				switch {
+				case s.wantLocked == 0 && err != nil:
+					t.Fatalf("step %d: %s error = %v", i+1, s.op, err)
+				case s.wantLocked == 0:
+				case !errors.As(err, &locked):
+					t.Fatalf("step %d: %s error = %v, want *LockedError", i+1, s.op, err)
+				case locked.RetryAfter != s.wantLocked:
+					t.Fatalf("step %d: RetryAfter = %s, want %s", i+1, locked.RetryAfter, s.wantLocked)
+				}
+			}
+		})
+	}
+}
+
+func TestGuardConcurrentReservations(t *testing.T) {
+	guard := NewGuard(NewMemoryStore(), Config{MaxFailuresPerEmail: 5})
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if guard.Reserve(testEmail, testIP, now) == nil {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The first reservation starts the per-failure delay, which refuses the others
+	if got := reserved.Load(); got != 1 {
+		t.Fatalf("%d concurrent attempts were let through, want 1", got)
+	}
+}
@@ -0,0 +1,82 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Record is the failure history of one key (an email or a client IP)
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	// ExpiresAt is when the record stops mattering: its failures have left the window
+	// and any lockout has ended
+	ExpiresAt time.Time
+}
+
+// Store keeps failure records. It is an interface so several gateway replicas can
+// share a backend; MemoryStore serves a single instance.
+type Store interface {
+	// Get returns the key's record, or the zero Record when there is none
+	Get(key string) (Record, error)
+
+	// CompareAndSwap stores next if the key's record still equals old (the zero Record
+	// when there is none) and reports whether it did. The Guard retries on false, so
+	// concurrent attempts never overwrite each other's counts.
+	CompareAndSwap(key string, old, next Record) (bool, error)
+
+	// Reset forgets the key
+	Reset(key string) error
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]Record),
+	}
+}
+
+func (s *MemoryStore) Get(key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.records[key], nil
+}
+
+func (s *MemoryStore) CompareAndSwap(key string, old, next Record) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !equalRecords(s.records[key], old) {
+		return false, nil
+	}
+	s.records[key] = next
+
+	// Forget keys that are neither locked nor within the window, as of this failure
+	for k, r := range s.records {
+		if next.LastFailure.After(r.ExpiresAt) {
+			delete(s.records, k)
+		}
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+func equalRecords(a, b Record) bool {
+	return a.Failures == b.Failures &&
+		a.LastFailure.Equal(b.LastFailure) &&
+		a.LockedUntil.Equal(b.LockedUntil) &&
+		a.ExpiresAt.Equal(b.ExpiresAt)
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	"github.com/loop/backend/rider-auth/lib/authz"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/rest/internals/apikeys"
+)
+
+// APIKeyHeader carries partner API keys
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyTokenTTL bounds the partner token minted for each API key request. It only has
+// to outlive the downstream gRPC calls the request makes.
+const apiKeyTokenTTL = 5 * time.Minute
+
+// APIKeyAuthenticator accepts the partner API key in the X-API-Key header, or as the
+// password of HTTP Basic authentication for OAuth-style client credentials. A valid key
+// yields a principal with the key's ID as its ClientID, no Subject, the partner role and
+// the key's scopes. A short-lived partner token (jwtlib.GeneratePartnerToken) with
+// those claims is forwarded to the gRPC services. It is not an access token: services
+// only accept it where their policy grants partner scopes, and handlers must take the
+// rider a partner acts for from the request and check it against the key. Chain it
+// before JWTAuthenticator:
+//
+//	middleware.Authenticate(authn.Chain(apiKeyAuthenticator, jwtAuthenticator), sessionStore)
+func APIKeyAuthenticator(store apikeys.Store, keyring *jwtlib.Keyring) authn.Authenticator {
+	return authn.AuthenticatorFunc(func(ctx context.Context, req authn.Request) (*authn.Principal, error) {
+		plaintext := req.Header(APIKeyHeader)
+		if plaintext == "" {
+			plaintext = basicPassword(req.Header("Authorization"))
+		}
+		if plaintext == "" {
+			return nil, authn.ErrNoCredentials
+		}
+
+		key, err := apikeys.Authenticate(store, plaintext, time.Now())
+		if err != nil {
+			if errors.Is(err, apikeys.ErrInvalidKey) || errors.Is(err, apikeys.ErrExpiredKey) || errors.Is(err, apikeys.ErrRevokedKey) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: failed to check API key: %v", authn.ErrUnavailable, err)
+		}
+
+		opts := append(jwtlib.GenerateOptionsFromEnv(),
+			jwtlib.WithRoles(authz.RolePartner),
+			jwtlib.WithScopes(key.Scopes...),
+		)
+		token, err := jwtlib.GeneratePartnerToken(key.ID, keyring, apiKeyTokenTTL, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to issue credentials: %v", authn.ErrUnavailable, err)
+		}
+		claims, err := jwtlib.VerifyPartnerToken(token, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to issue credentials: %v", authn.ErrUnavailable, err)
+		}
+
+		principal := authn.PrincipalFromClaims(claims, authn.MethodAPIKey)
+		principal.Credential = "Bearer " + token
+		return principal, nil
+	})
+}
+
+// basicPassword returns the password of a Basic Authorization header, empty for any
+// other scheme
+func basicPassword(header string) string {
+	r := http.Request{Header: http.Header{"Authorization": {header}}}
+	_, password, ok := r.BasicAuth()
+	if !ok {
+		return ""
+	}
+	return password
+}
+
+// RequireAPIKeyScopes checks scopes only for callers authenticated with an API key.
+// Riders' own access tokens carry no scopes and pass through.
+func RequireAPIKeyScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		scoped := RequireScopes(scopes...)(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := authn.PrincipalFromContext(r.Context())
+			if !ok || principal.Method != authn.MethodAPIKey {
+				next.ServeHTTP(w, r)
+				return
+			}
+			scoped.ServeHTTP(w, r)
+		})
+	}
+}
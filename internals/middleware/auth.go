@@ -2,71 +2,152 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"strings"
+	"time"
 
+	"github.com/loop/backend/rider-auth/lib/authn"
 	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/rest/internals/apikeys"
+	"github.com/loop/backend/rider-auth/rest/internals/sessions"
 )
 
 type contextKey string
 
-const (
-	RiderIDKey contextKey = "riderId"
-	EmailKey   contextKey = "email"
-)
+// RefreshPath is where clients exchange their refresh token for a new access token
+const RefreshPath = "/api/auth/refresh"
+
+// JWTAuthenticator accepts an access token from the Authorization header or the
+// access_token cookie and rejects tokens recorded in the revocation store, including
+// tokens whose session was revoked. opts add issuer, audience, algorithm and claim
+// requirements.
+func JWTAuthenticator(keys jwtlib.KeySource, revocations jwtlib.RevocationStore, opts ...jwtlib.VerifyOption) authn.Authenticator {
+	verifyOpts := append([]jwtlib.VerifyOption{jwtlib.CheckRevocation(revocations)}, opts...)
+
+	return authn.Chain(
+		authn.Bearer(keys, verifyOpts...),
+		authn.Cookie("access_token", keys, verifyOpts...),
+	)
+}
 
-func JWTVerifyMiddleware(secretKey string) func(http.Handler) http.Handler {
+// JWTVerifyMiddleware is Authenticate with JWTAuthenticator
+func JWTVerifyMiddleware(keys jwtlib.KeySource, revocations jwtlib.RevocationStore, sessionStore sessions.Store, opts ...jwtlib.VerifyOption) func(http.Handler) http.Handler {
+	return Authenticate(JWTAuthenticator(keys, revocations, opts...), sessionStore)
+}
+
+// Authenticate rejects requests that authenticator does not accept and records the
+// principal in the request context for authn.PrincipalFromContext. The principal's
+// credential is forwarded to the gRPC services. Activity is recorded on the principal's
+// session when sessionStore is not nil.
+func Authenticate(authenticator authn.Authenticator, sessionStore sessions.Store) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Extract token from Authorization header or cookie
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				cookie, err := r.Cookie("access_token")
-				if err == nil {
-					authHeader = cookie.Value
-				}
-			}
-
-			if authHeader == "" {
-				http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+			principal, err := authenticator.Authenticate(r.Context(), authn.FromHTTP(r))
+			if err != nil {
+				authenticationFailed(w, err)
 				return
 			}
 
-			token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer"))
-			if token == "" {
-				http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
-				return
+			ctx := authn.WithPrincipal(r.Context(), principal)
+			if principal.Credential != "" {
+				ctx = withCredential(ctx, principal.Credential)
 			}
 
-			claims, err := jwtlib.VerifyToken(token, secretKey)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Invalid or expired token: %v", err), http.StatusUnauthorized)
-				return
+			if sessionStore != nil && principal.SessionID != "" {
+				if err := sessionStore.Touch(principal.SessionID, time.Now()); err != nil {
+					log.Printf("Failed to record session activity: %v", err)
+				}
 			}
 
-			ctx := context.WithValue(r.Context(), RiderIDKey, claims.UserID)
-			ctx = context.WithValue(ctx, EmailKey, claims.Email)
-
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-// GetRiderIDFromContext extracts rider ID from request context
-func GetRiderIDFromContext(ctx context.Context) (string, error) {
-	riderID, ok := ctx.Value(RiderIDKey).(string)
-	if !ok || riderID == "" {
-		return "", fmt.Errorf("rider ID not found in context")
+func authenticationFailed(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, authn.ErrNoCredentials):
+		unauthorized(w, "Missing authorization token", "missing_token")
+	case errors.Is(err, authn.ErrUnavailable):
+		log.Printf("Authentication failed: %v", err)
+		http.Error(w, "Failed to check credentials", http.StatusInternalServerError)
+	case errors.Is(err, apikeys.ErrInvalidKey), errors.Is(err, apikeys.ErrExpiredKey), errors.Is(err, apikeys.ErrRevokedKey):
+		unauthorized(w, err.Error(), "invalid_api_key")
+	default:
+		unauthorized(w, tokenErrorMessage(err), jwtlib.ErrorCode(err))
+	}
+}
+
+// RequireVerifiedEmail rejects riders whose access token does not carry
+// email_verified. Partners have no email of their own and pass. It must run after
+// JWTVerifyMiddleware.
+func RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := authn.PrincipalFromContext(r.Context()); ok && principal.ClientID != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !IsEmailVerified(r.Context()) {
+			http.Error(w, "Email address not verified; verify it or request a new link via POST /api/auth/verify-email/resend", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unauthorized writes a 401 whose WWW-Authenticate header carries the machine readable
+// code. The access cookie expires together with the access token while the refresh
+// cookie lives longer, so for a missing or expired token clients are pointed at the
+// refresh endpoint before the rider is sent back to the login screen.
+func unauthorized(w http.ResponseWriter, message string, code string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, code))
+	if code == "missing_token" || code == "token_expired" {
+		message += "; refresh via POST " + RefreshPath
+	}
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// tokenErrorMessage turns a VerifyToken error into the text shown to clients
+func tokenErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, jwtlib.ErrExpired):
+		return "Access token expired"
+	case errors.Is(err, jwtlib.ErrTokenRevoked):
+		return "Token has been revoked, please login again"
+	case errors.Is(err, jwtlib.ErrWrongAudience), errors.Is(err, jwtlib.ErrWrongIssuer):
+		return "Token was not issued for this service"
+	case errors.Is(err, jwtlib.ErrWrongTokenType):
+		return "Only access tokens are accepted here"
+	case errors.Is(err, jwtlib.ErrBadAlgorithm):
+		return "Token signed with an unsupported algorithm"
+	default:
+		return fmt.Sprintf("Invalid token: %v", err)
 	}
-	return riderID, nil
 }
 
-// GetEmailFromContext extracts email from request context
+// GetEmailFromContext returns the authenticated principal's email
 func GetEmailFromContext(ctx context.Context) (string, error) {
-	email, ok := ctx.Value(EmailKey).(string)
-	if !ok || email == "" {
+	principal, ok := authn.PrincipalFromContext(ctx)
+	if !ok || principal.Email == "" {
 		return "", fmt.Errorf("email not found in context")
 	}
-	return email, nil
+	return principal.Email, nil
+}
+
+// GetSessionIDFromContext returns the sid claim of the request's access token, empty
+// for tokens issued before sessions were tracked and for API keys
+func GetSessionIDFromContext(ctx context.Context) string {
+	principal, ok := authn.PrincipalFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return principal.SessionID
+}
+
+// IsEmailVerified reports whether the principal's email is verified
+func IsEmailVerified(ctx context.Context) bool {
+	principal, ok := authn.PrincipalFromContext(ctx)
+	return ok && principal.EmailVerified
 }
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/loop/backend/rider-auth/lib/authn"
+	"github.com/loop/backend/rider-auth/lib/authz"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+)
+
+// RequireRoles lets the request through when the principal holds any one of roles.
+// It must run after Authenticate:
+//
+//	jwtMiddleware(middleware.RequireRoles(authz.RoleSupport, authz.RoleAdmin)(handler))
+func RequireRoles(roles ...string) func(http.Handler) http.Handler {
+	return Require(authz.Roles(roles...))
+}
+
+// RequireScopes lets the request through when the principal was granted all of scopes.
+// It must run after Authenticate.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return Require(authz.Scopes(scopes...))
+}
+
+// Require answers 403 with a JSON error body when the principal does not meet
+// requirement. The error field carries the same code the gRPC services put in their
+// PermissionDenied status.
+func Require(requirement authz.Requirement) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := authn.PrincipalFromContext(r.Context())
+			if !ok {
+				unauthorized(w, "Missing authorization token", "missing_token")
+				return
+			}
+
+			if err := authz.Check(principal, requirement); err != nil {
+				forbidden(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func forbidden(w http.ResponseWriter, err error) {
+	resp := models.ErrorResponse{
+		Success: false,
+		Message: "Forbidden",
+		Status:  http.StatusForbidden,
+		Error:   err.Error(),
+	}
+
+	var denied *authz.DeniedError
+	if errors.As(err, &denied) {
+		resp.Message = "Forbidden: " + denied.Message
+		resp.Error = denied.Code
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:4000", want: "203.0.113.7"},
+		{name: "header from an untrusted peer", remoteAddr: "203.0.113.7:4000", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "one trusted proxy", remoteAddr: "10.0.0.5:4000", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "client supplied entry", remoteAddr: "10.0.0.5:4000", forwarded: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.5:4000", forwarded: []string{"1.2.3.4, 198.51.100.1, 192.168.1.10, 10.1.1.1"}, want: "198.51.100.1"},
+		{name: "repeated headers", remoteAddr: "10.0.0.5:4000", forwarded: []string{"1.2.3.4", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "garbage entry", remoteAddr: "10.0.0.5:4000", forwarded: []string{"198.51.100.1, not-an-ip"}, want: "not-an-ip"},
+		{name: "only trusted hops", remoteAddr: "10.0.0.5:4000", forwarded: []string{"10.2.2.2, 10.1.1.1"}, want: "10.2.2.2"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.5:4000", want: "10.0.0.5"},
+		{name: "ipv6 proxy", remoteAddr: "[fd00::1]:4000", forwarded: []string{"2001:db8::1"}, want: "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, forwarded := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", forwarded)
+			}
+
+			var got string
+			ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = GetClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Errorf("ParseTrustedProxies accepted an invalid range")
+	}
+}
@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+	"github.com/loop/backend/rider-auth/rest/internals/models"
+)
+
+const (
+	// CSRFCookie is readable by the frontend, which echoes it in CSRFHeader
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CSRF implements signed double-submit tokens. A token is a random nonce plus an HMAC
+// of the nonce and the session ID of the access token, so a cookie planted from a
+// sibling subdomain, or copied from the attacker's own session, does not validate.
+//
+// The check only applies to unsafe methods whose credential is a cookie; requests
+// authenticated with an Authorization or X-API-Key header cannot be forged cross-site.
+type CSRF struct {
+	secret         []byte
+	exemptPrefixes []string
+}
+
+// NewCSRF signs tokens with secret. Requests whose path starts with one of
+// exemptPrefixes, such as webhooks that authenticate with their own signatures, are
+// never checked.
+func NewCSRF(secret []byte, exemptPrefixes ...string) *CSRF {
+	return &CSRF{
+		secret:         secret,
+		exemptPrefixes: exemptPrefixes,
+	}
+}
+
+// Middleware checks incoming requests and issues a token cookie whenever a response
+// sets new auth cookies, or when a cookie-authenticated request lacks a valid token
+func (c *CSRF) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookieAuth := cookieCredential(r)
+		sessionID := cookieSessionID(r)
+
+		cookieToken := ""
+		if cookie, err := r.Cookie(CSRFCookie); err == nil {
+			cookieToken = cookie.Value
+		}
+		hasValidCookie := cookieToken != "" && c.valid(cookieToken, sessionID)
+
+		if cookieAuth && !safeMethod(r.Method) {
+			headerToken := r.Header.Get(CSRFHeader)
+			if !hasValidCookie || subtle.ConstantTimeCompare([]byte(headerToken), []byte(cookieToken)) != 1 {
+				if !hasValidCookie {
+					c.setCookie(w, sessionID)
+				}
+				csrfFailed(w)
+				return
+			}
+		}
+
+		cw := &csrfWriter{ResponseWriter: w, csrf: c}
+		if cookieAuth && !hasValidCookie {
+			cw.issueFor = &sessionID
+		}
+		next.ServeHTTP(cw, r)
+	})
+}
+
+func (c *CSRF) exempt(path string) bool {
+	for _, prefix := range c.exemptPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CSRF) newToken(sessionID string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(nonce)
+	return encoded + "." + c.sign(encoded, sessionID), nil
+}
+
+func (c *CSRF) valid(token string, sessionID string) bool {
+	nonce, signature, ok := strings.Cut(token, ".")
+	if !ok || nonce == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(c.sign(nonce, sessionID)))
+}
+
+func (c *CSRF) sign(nonce string, sessionID string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(sessionID + "." + nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *CSRF) setCookie(w http.ResponseWriter, sessionID string) {
+	token, err := c.newToken(sessionID)
+	if err != nil {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: false, // the frontend reads it to fill in the header
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func (c *CSRF) clearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// csrfWriter issues or clears the token cookie just before the response headers are
+// written, once it is known whether the handler set or cleared the auth cookies
+type csrfWriter struct {
+	http.ResponseWriter
+	csrf        *CSRF
+	issueFor    *string
+	wroteHeader bool
+}
+
+func (cw *csrfWriter) WriteHeader(statusCode int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.beforeHeader()
+	}
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (cw *csrfWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *csrfWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *csrfWriter) beforeHeader() {
+	for _, line := range cw.Header().Values("Set-Cookie") {
+		cookie, err := http.ParseSetCookie(line)
+		if err != nil || cookie.Name != "access_token" {
+			continue
+		}
+
+		if cookie.MaxAge < 0 || cookie.Value == "" {
+			cw.csrf.clearCookie(cw.ResponseWriter)
+		} else {
+			cw.csrf.setCookie(cw.ResponseWriter, tokenSessionID(cookie.Value))
+		}
+		return
+	}
+
+	if cw.issueFor != nil {
+		cw.csrf.setCookie(cw.ResponseWriter, *cw.issueFor)
+	}
+}
+
+// cookieCredential reports whether the request would be authenticated by a cookie
+// rather than a header
+func cookieCredential(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" || r.Header.Get(APIKeyHeader) != "" {
+		return false
+	}
+	for _, name := range []string{"access_token", "refresh_token"} {
+		if _, err := r.Cookie(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// cookieSessionID returns the sid of the access cookie, or of the refresh cookie once
+// the access cookie has expired. The tokens are verified later by the auth middleware;
+// a forged sid only yields a token that fails against the real session.
+func cookieSessionID(r *http.Request) string {
+	for _, name := range []string{"access_token", "refresh_token"} {
+		if cookie, err := r.Cookie(name); err == nil {
+			return tokenSessionID(cookie.Value)
+		}
+	}
+	return ""
+}
+
+func tokenSessionID(value string) string {
+	claims, err := jwtlib.PeekClaims(strings.TrimSpace(strings.TrimPrefix(value, "Bearer")))
+	if err != nil {
+		return ""
+	}
+	return claims.SessionID
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func csrfFailed(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Success: false,
+		Message: "Forbidden: missing or invalid " + CSRFHeader + " header; copy it from the " + CSRFCookie + " cookie",
+		Status:  http.StatusForbidden,
+		Error:   "csrf_token_invalid",
+	})
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	keyring, err := jwtlib.NewSingleKeyring("test-secret-test-secret-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := jwtlib.GenerateAccessToken("rider@example.com", "rider-1", keyring, time.Minute, jwtlib.WithSessionID("s1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrf := NewCSRF([]byte("csrf-secret"), "/api/webhooks/")
+	token, err := csrf.newToken("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSessionToken, err := csrf.newToken("s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		accessToken string
+		cookieToken string
+		headerToken string
+		bearer      bool
+		// login makes the handler set a new access cookie
+		login      bool
+		wantStatus int
+		wantCookie bool
+	}{
+		{
+			name:        "safe method without a token",
+			method:      http.MethodGet,
+			accessToken: accessToken,
+			wantStatus:  http.StatusOK,
+			wantCookie:  true,
+		},
+		{
+			name:        "unsafe method with matching token",
+			method:      http.MethodPost,
+			accessToken: accessToken,
+			cookieToken: token,
+			headerToken: token,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "unsafe method without a header",
+			method:      http.MethodPost,
+			accessToken: accessToken,
+			cookieToken: token,
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "unsafe method without a cookie",
+			method:      http.MethodPost,
+			accessToken: accessToken,
+			headerToken: token,
+			wantStatus:  http.StatusForbidden,
+			wantCookie:  true,
+		},
+		{
+			name:        "token of another session",
+			method:      http.MethodPost,
+			accessToken: accessToken,
+			cookieToken: otherSessionToken,
+			headerToken: otherSessionToken,
+			wantStatus:  http.StatusForbidden,
+			wantCookie:  true,
+		},
+		{
+			name:        "header authenticated request",
+			method:      http.MethodPost,
+			accessToken: accessToken,
+			bearer:      true,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "exempt path",
+			method:      http.MethodPost,
+			path:        "/api/webhooks/payment",
+			accessToken: accessToken,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:       "login response issues a token",
+			method:     http.MethodPost,
+			login:      true,
+			wantStatus: http.StatusOK,
+			wantCookie: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.login {
+					http.SetCookie(w, &http.Cookie{Name: "access_token", Value: accessToken})
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+
+			path := tt.path
+			if path == "" {
+				path = "/api/auth/rider/update"
+			}
+			req := httptest.NewRequest(tt.method, path, nil)
+			if tt.accessToken != "" {
+				if tt.bearer {
+					req.Header.Set("Authorization", "Bearer "+tt.accessToken)
+				} else {
+					req.AddCookie(&http.Cookie{Name: "access_token", Value: tt.accessToken})
+				}
+			}
+			if tt.cookieToken != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.cookieToken})
+			}
+			if tt.headerToken != "" {
+				req.Header.Set(CSRFHeader, tt.headerToken)
+			}
+			rec := httptest.NewRecorder()
+
+			csrf.Middleware(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			var issued *http.Cookie
+			for _, cookie := range rec.Result().Cookies() {
+				if cookie.Name == CSRFCookie {
+					issued = cookie
+				}
+			}
+			if (issued != nil) != tt.wantCookie {
+				t.Fatalf("issued %s cookie = %v, want %v", CSRFCookie, issued != nil, tt.wantCookie)
+			}
+			if issued != nil && !csrf.valid(issued.Value, "s1") {
+				t.Errorf("issued token %q is not valid for the session", issued.Value)
+			}
+		})
+	}
+}
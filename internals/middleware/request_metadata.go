@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	grpcmw "github.com/loop/backend/rider-auth/lib/middleware"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+const RequestIDKey contextKey = "requestId"
+
+// requestIDPattern bounds what a client may send as its own request ID
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestMetadata assigns each request an ID, reusing a well-formed X-Request-ID from
+// the client, echoes it in the response and records the details forwarded to the gRPC
+// services. It must run after ClientIP; the auth middlewares add the credential.
+func RequestMetadata(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
+		ctx = grpcmw.WithCallMetadata(ctx, grpcmw.CallMetadata{
+			RequestID: requestID,
+			ClientIP:  GetClientIP(r),
+			UserAgent: r.UserAgent(),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetRequestID returns the ID assigned by RequestMetadata
+func GetRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(RequestIDKey).(string)
+	return requestID
+}
+
+// withCredential makes credential, "Bearer <token>", the one forwarded to the gRPC
+// services for the rest of the request
+func withCredential(ctx context.Context, credential string) context.Context {
+	md, _ := grpcmw.CallMetadataFromContext(ctx)
+	md.Authorization = credential
+	return grpcmw.WithCallMetadata(ctx, md)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
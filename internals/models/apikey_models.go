@@ -0,0 +1,42 @@
+package models
+
+// CreateAPIKeyRequest represents the request body for issuing a partner API key
+type CreateAPIKeyRequest struct {
+	Owner  string   `json:"owner"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// RiderIDs are the riders the key may create checkouts for; required with checkout:create
+	RiderIDs []string `json:"rider_ids,omitempty"`
+	// ExpiresInDays defaults to 90 and may not exceed 365
+	ExpiresInDays int `json:"expires_in_days,omitempty"`
+}
+
+// APIKey describes an API key without its secret
+type APIKey struct {
+	ID         string   `json:"id"`
+	Owner      string   `json:"owner"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	RiderIDs   []string `json:"rider_ids,omitempty"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	LastUsedAt int64    `json:"last_used_at,omitempty"`
+	RevokedAt  int64    `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyResponse carries the plaintext key, which is shown only once
+type CreateAPIKeyResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Status  int64  `json:"status"`
+	Key     string `json:"key"`
+	APIKey  APIKey `json:"api_key"`
+}
+
+// APIKeysResponse represents the response for listing API keys
+type APIKeysResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	Status  int64    `json:"status"`
+	Keys    []APIKey `json:"keys"`
+}
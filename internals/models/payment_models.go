@@ -6,6 +6,9 @@ type Coordinates struct {
 }
 
 type CreateCheckoutSessionRequest struct {
+	// RiderID is the rider a partner books for; required with an API key, and riders
+	// may only give their own ID
+	RiderID              string      `json:"rider_id,omitempty"`
 	EstimatedPrice       float32     `json:"estimated_price"`
 	PickupLocation       string      `json:"pickup_location"`
 	DropoffLocation      string      `json:"dropoff_location"`
@@ -8,12 +8,14 @@ type RegisterRequest struct {
 	PhoneNumber string `json:"phone_number"`
 	BirthMonth  string `json:"birth_month"`
 	BirthYear   int64  `json:"birth_year"`
+	DeviceName  string `json:"device_name,omitempty"`
 }
 
 // LoginRequest represents the request body for user login
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	DeviceName string `json:"device_name,omitempty"`
 }
 
 // User represents the user information in responses
@@ -53,6 +55,53 @@ type LoginResponse struct {
 	Token   *Tokens `json:"token,omitempty"`
 }
 
+// RefreshTokenRequest represents the optional request body for token refresh
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshTokenResponse represents the response for token refresh
+type RefreshTokenResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message"`
+	Status  int64   `json:"status"`
+	Token   *Tokens `json:"token,omitempty"`
+}
+
+// LogoutResponse represents the response for logout
+type LogoutResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Status  int64  `json:"status"`
+}
+
+// UpdateRiderRequest represents a partial profile update. Omitted fields are left
+// unchanged; updated_at must be the value the client last read. Changing email or
+// phone_number also needs current_password, or current_phone_code texted to the
+// rider's current number, and a new phone number needs phone_code texted to it.
+type UpdateRiderRequest struct {
+	Email            *string `json:"email,omitempty"`
+	FullName         *string `json:"full_name,omitempty"`
+	PhoneNumber      *string `json:"phone_number,omitempty"`
+	BirthMonth       *string `json:"birth_month,omitempty"`
+	BirthYear        *int64  `json:"birth_year,omitempty"`
+	UpdatedAt        int64   `json:"updated_at"`
+	CurrentPassword  string  `json:"current_password,omitempty"`
+	CurrentPhoneCode string  `json:"current_phone_code,omitempty"`
+	PhoneCode        string  `json:"phone_code,omitempty"`
+}
+
+// UpdateRiderResponse represents the response for a profile update. On a conflict
+// it carries the rider's current profile. pending_email is a new address that takes
+// effect once the link mailed to it is opened.
+type UpdateRiderResponse struct {
+	Success      bool   `json:"success"`
+	Message      string `json:"message"`
+	Status       int64  `json:"status"`
+	User         *User  `json:"user,omitempty"`
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
 // GetRiderDetailsResponse represents the response for getting rider details
 type GetRiderDetailsResponse struct {
 	Success bool   `json:"success"`
@@ -61,6 +110,140 @@ type GetRiderDetailsResponse struct {
 	User    *User  `json:"user,omitempty"`
 }
 
+// ForgotPasswordRequest represents the request body for starting a password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest represents the request body for completing a password reset
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePasswordRequest represents the request body for changing a logged in rider's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePasswordResponse represents the response for a password change. Clients that
+// authenticate with the Authorization header get their new tokens in the body.
+type ChangePasswordResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message"`
+	Status  int64   `json:"status"`
+	Token   *Tokens `json:"token,omitempty"`
+}
+
+// DeleteRiderRequest re-authenticates a rider asking to delete their account. Code is
+// required when two-factor authentication is enabled.
+type DeleteRiderRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"`
+}
+
+// DeleteRiderResponse represents the response for scheduling an account deletion
+type DeleteRiderResponse struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	Status   int64  `json:"status"`
+	DeleteAt int64  `json:"delete_at"`
+}
+
+// CancelDeletionRequest represents the request body for cancelling a scheduled deletion
+type CancelDeletionRequest struct {
+	Token string `json:"token"`
+}
+
+// PaymentSession represents a checkout session in a data export
+type PaymentSession struct {
+	SessionID       string  `json:"session_id"`
+	PaymentIntentID string  `json:"payment_intent_id"`
+	Status          string  `json:"status"`
+	PickupLocation  string  `json:"pickup_location"`
+	DropoffLocation string  `json:"dropoff_location"`
+	EstimatedPrice  float32 `json:"estimated_price"`
+	CreatedAt       int64   `json:"created_at"`
+}
+
+// SecuritySettings represents the rider's two-factor settings in a data export. No
+// secrets are included.
+type SecuritySettings struct {
+	MFAEnabled           bool  `json:"mfa_enabled"`
+	MFAConfirmedAt       int64 `json:"mfa_confirmed_at,omitempty"`
+	BackupCodesRemaining int   `json:"backup_codes_remaining"`
+}
+
+// RiderExport is everything the gateway can collect about a rider
+type RiderExport struct {
+	ExportedAt      int64            `json:"exported_at"`
+	Profile         *User            `json:"profile"`
+	Sessions        []Session        `json:"sessions"`
+	PaymentSessions []PaymentSession `json:"payment_sessions"`
+	Security        SecuritySettings `json:"security"`
+}
+
+// StartOTPRequest represents the request body for requesting a phone login code
+type StartOTPRequest struct {
+	PhoneNumber string `json:"phone_number"`
+}
+
+// VerifyOTPRequest represents the request body for logging in with a phone code
+type VerifyOTPRequest struct {
+	PhoneNumber string `json:"phone_number"`
+	Code        string `json:"code"`
+	DeviceName  string `json:"device_name,omitempty"`
+}
+
+// MFACodeRequest represents a request carrying an authenticator or backup code.
+// CurrentPassword is required to disable two-factor authentication.
+type MFACodeRequest struct {
+	Code            string `json:"code"`
+	CurrentPassword string `json:"current_password,omitempty"`
+}
+
+// MFAVerifyRequest represents the request body for completing a two-factor login
+type MFAVerifyRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	DeviceName     string `json:"device_name,omitempty"`
+}
+
+// MFAEnrollResponse represents the response for starting TOTP enrollment
+type MFAEnrollResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	Status     int64  `json:"status"`
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRCodePNG  string `json:"qr_code_png"` // base64 encoded PNG
+}
+
+// MFABackupCodesResponse represents a response carrying freshly generated backup codes
+type MFABackupCodesResponse struct {
+	Success     bool     `json:"success"`
+	Message     string   `json:"message"`
+	Status      int64    `json:"status"`
+	BackupCodes []string `json:"backup_codes"`
+}
+
+// MFAChallengeResponse represents the login response for riders with two-factor enabled
+type MFAChallengeResponse struct {
+	Success        bool   `json:"success"`
+	Message        string `json:"message"`
+	Status         int64  `json:"status"`
+	MFARequired    bool   `json:"mfa_required"`
+	ChallengeToken string `json:"challenge_token"`
+}
+
+// MessageResponse represents a response that carries no data beyond its message
+type MessageResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Status  int64  `json:"status"`
+}
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
 	Success bool   `json:"success"`
@@ -68,3 +251,47 @@ type ErrorResponse struct {
 	Status  int64  `json:"status"`
 	Error   string `json:"error,omitempty"`
 }
+
+// Session represents one device the rider is logged in on
+type Session struct {
+	ID         string `json:"id"`
+	DeviceName string `json:"device_name,omitempty"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+// SessionsResponse represents the response for listing active sessions
+type SessionsResponse struct {
+	Success  bool      `json:"success"`
+	Message  string    `json:"message"`
+	Status   int64     `json:"status"`
+	Sessions []Session `json:"sessions"`
+}
+
+// OAuthErrorResponse is the RFC 6749 error body used by the introspection endpoint
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// IntrospectionResponse is the RFC 7662 token introspection response. Inactive tokens
+// carry only active=false.
+type IntrospectionResponse struct {
+	Active        bool     `json:"active"`
+	Scope         string   `json:"scope,omitempty"`
+	TokenType     string   `json:"token_type,omitempty"`
+	Exp           int64    `json:"exp,omitempty"`
+	Iat           int64    `json:"iat,omitempty"`
+	Nbf           int64    `json:"nbf,omitempty"`
+	Sub           string   `json:"sub,omitempty"`
+	Aud           []string `json:"aud,omitempty"`
+	Iss           string   `json:"iss,omitempty"`
+	Jti           string   `json:"jti,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	SessionID     string   `json:"sid,omitempty"`
+	Roles         []string `json:"roles,omitempty"`
+}
@@ -0,0 +1,81 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// wellKnownIssuers lets env configuration omit the issuer for common providers
+var wellKnownIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+	"apple":  "https://appleid.apple.com",
+}
+
+type providersFile struct {
+	Providers []Config `json:"providers"`
+}
+
+// LoadProviders reads provider configuration from OIDC_PROVIDERS_FILE, a JSON document
+// of the form {"providers": [Config, ...]}, or else from OIDC_PROVIDERS, a comma
+// separated list of names each configured by OIDC_<NAME>_ISSUER, _CLIENT_ID,
+// _CLIENT_SECRET, _REDIRECT_URL, _SCOPES (space separated) and _RESPONSE_MODE.
+//
+// Apple expects a client secret JWT signed with the team's key; generate it ahead of
+// time (it may live up to six months) and configure it as the client secret.
+func LoadProviders() (map[string]*Provider, error) {
+	var configs []Config
+
+	if path := os.Getenv("OIDC_PROVIDERS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("oidc: %w", err)
+		}
+
+		var file providersFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("oidc: failed to parse %s: %w", path, err)
+		}
+		configs = file.Providers
+	} else {
+		for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			configs = append(configs, configFromEnv(name))
+		}
+	}
+
+	providers := make(map[string]*Provider, len(configs))
+	for _, cfg := range configs {
+		if cfg.Issuer == "" {
+			cfg.Issuer = wellKnownIssuers[cfg.Name]
+		}
+
+		provider, err := NewProvider(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := providers[cfg.Name]; ok {
+			return nil, fmt.Errorf("oidc: provider %q configured twice", cfg.Name)
+		}
+		providers[cfg.Name] = provider
+	}
+	return providers, nil
+}
+
+func configFromEnv(name string) Config {
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+	return Config{
+		Name:         name,
+		Issuer:       os.Getenv(prefix + "ISSUER"),
+		ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		ResponseMode: os.Getenv(prefix + "RESPONSE_MODE"),
+	}
+}
@@ -0,0 +1,266 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	jwtlib "github.com/loop/backend/rider-auth/lib/jwt"
+)
+
+const (
+	jwksCacheTTL      = time.Hour
+	discoveryCacheTTL = 24 * time.Hour
+)
+
+var ErrNonceMismatch = errors.New("id token nonce does not match")
+
+// Config describes one OpenID provider the gateway signs riders in with
+type Config struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+
+	// ResponseMode is "form_post" for providers, such as Apple, that POST the callback
+	// when name or email scopes are requested. Empty means the default query mode.
+	ResponseMode string `json:"response_mode,omitempty"`
+}
+
+// Flow holds the per-login secrets kept in short-lived cookies between start and callback
+type Flow struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// IDTokenClaims are the claims the gateway reads from a provider's ID token
+type IDTokenClaims struct {
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	Name          string   `json:"name"`
+	Nonce         string   `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// Provider is an OpenID Connect relying party for one issuer. Endpoints come from the
+// issuer's discovery document and signing keys from its JWKS, both cached.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu           sync.Mutex
+	discovery    *discoveryDocument
+	discoveredAt time.Time
+	keys         jwtlib.KeySource
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func NewProvider(cfg Config) (*Provider, error) {
+	if cfg.Name == "" || cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc: provider %q needs name, issuer, client_id and redirect_url", cfg.Name)
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+
+	return &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// NewFlow generates the state, nonce and PKCE verifier for one login attempt
+func NewFlow() (Flow, error) {
+	var flow Flow
+	for _, field := range []*string{&flow.State, &flow.Nonce, &flow.CodeVerifier} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Flow{}, err
+		}
+		*field = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return flow, nil
+}
+
+// AuthCodeURL is where the rider's browser is sent to sign in with the provider
+func (p *Provider) AuthCodeURL(ctx context.Context, flow Flow) (string, error) {
+	doc, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(flow.CodeVerifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", flow.State)
+	q.Set("nonce", flow.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	if p.cfg.ResponseMode != "" {
+		q.Set("response_mode", p.cfg.ResponseMode)
+	}
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the validated ID token claims.
+// The token must be signed by the issuer, addressed to our client ID, unexpired and
+// carry the nonce from this flow.
+func (p *Provider) Exchange(ctx context.Context, code string, flow Flow) (*IDTokenClaims, error) {
+	doc, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", flow.CodeVerifier)
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("oidc: invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("oidc: token request rejected: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token response has no id_token")
+	}
+
+	claims := &IDTokenClaims{}
+	err = jwtlib.ParseVerified(tokens.IDToken, keys, claims,
+		jwtlib.RequireIssuer(doc.Issuer),
+		jwtlib.RequireAudience(p.cfg.ClientID),
+		jwtlib.AllowAlgorithms(jwtlib.AlgRS256, jwtlib.AlgEdDSA),
+		jwtlib.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: invalid id token: %w", err)
+	}
+
+	if claims.Nonce != flow.Nonce {
+		return nil, ErrNonceMismatch
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("oidc: id token has no subject")
+	}
+
+	return claims, nil
+}
+
+// discover fetches and caches the issuer's discovery document and JWKS source
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, jwtlib.KeySource, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < discoveryCacheTTL {
+		return p.discovery, p.keys, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc: discovery for %s failed: %w", p.cfg.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("oidc: discovery for %s failed: status %d", p.cfg.Name, resp.StatusCode)
+	}
+
+	var doc discoveryDocument
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("oidc: invalid discovery document for %s: %w", p.cfg.Name, err)
+	}
+
+	if strings.TrimSuffix(doc.Issuer, "/") != p.cfg.Issuer {
+		return nil, nil, fmt.Errorf("oidc: discovery issuer %q does not match configured %q", doc.Issuer, p.cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("oidc: discovery document for %s is incomplete", p.cfg.Name)
+	}
+
+	if p.discovery == nil || p.discovery.JWKSURI != doc.JWKSURI {
+		p.keys = jwtlib.NewRemoteJWKS(doc.JWKSURI, jwksCacheTTL)
+	}
+	p.discovery = &doc
+	p.discoveredAt = time.Now()
+	return p.discovery, p.keys, nil
+}
+
+// flexBool accepts both JSON booleans and the "true"/"false" strings Apple sends
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null", "":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
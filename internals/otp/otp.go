@@ -0,0 +1,172 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	defaultCodeTTL     = 5 * time.Minute
+	defaultMaxAttempts = 5
+	defaultSendWindow  = 15 * time.Minute
+	defaultMaxSends    = 3
+	defaultIPWindow    = time.Hour
+	defaultMaxIPSends  = 20
+)
+
+var (
+	ErrRateLimited     = errors.New("too many codes requested")
+	ErrNoChallenge     = errors.New("no code was requested for this number")
+	ErrExpired         = errors.New("code has expired")
+	ErrInvalidCode     = errors.New("code is invalid")
+	ErrTooManyAttempts = errors.New("too many incorrect attempts")
+)
+
+// codeSpace is the number of distinct 6-digit codes
+var codeSpace = big.NewInt(1000000)
+
+// RateLimitError is returned by Start when a number, or a client IP, asked for too
+// many codes
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", ErrRateLimited, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// Manager issues and checks 6-digit one-time codes. Codes are only ever stored as an
+// HMAC of the number and code, expire after a few minutes, allow a limited number of
+// guesses, and each number and each client IP may only request a limited number of
+// codes per window. The IP limit stops one client from texting many numbers.
+type Manager struct {
+	store       Store
+	secret      []byte
+	codeTTL     time.Duration
+	maxAttempts int
+	sendWindow  time.Duration
+	maxSends    int
+	ipWindow    time.Duration
+	maxIPSends  int
+}
+
+func NewManager(store Store, secret []byte) (*Manager, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("otp: secret must not be empty")
+	}
+
+	return &Manager{
+		store:       store,
+		secret:      secret,
+		codeTTL:     defaultCodeTTL,
+		maxAttempts: defaultMaxAttempts,
+		sendWindow:  defaultSendWindow,
+		maxSends:    defaultMaxSends,
+		ipWindow:    defaultIPWindow,
+		maxIPSends:  defaultMaxIPSends,
+	}, nil
+}
+
+// CodeTTL is how long a code stays valid
+func (m *Manager) CodeTTL() time.Duration {
+	return m.codeTTL
+}
+
+// Start generates a new code for phone, replacing any previous one, and returns it in
+// plain text for delivery. ip is the requesting client. It returns a *RateLimitError
+// when the number or the IP is over its limit. The IP is reserved first, so requests
+// from a client over its limit never use up the number's sends.
+func (m *Manager) Start(phone string, ip string) (string, error) {
+	now := time.Now()
+
+	if ip != "" {
+		if err := m.reserveSend(ipSendKey(ip), now, m.ipWindow, m.maxIPSends); err != nil {
+			return "", err
+		}
+	}
+	if err := m.reserveSend(phone, now, m.sendWindow, m.maxSends); err != nil {
+		return "", err
+	}
+
+	n, err := rand.Int(rand.Reader, codeSpace)
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	challenge := Challenge{
+		CodeHash:  m.hash(phone, code),
+		ExpiresAt: now.Add(m.codeTTL),
+	}
+	if err := m.store.Put(phone, challenge); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+func (m *Manager) reserveSend(key string, now time.Time, window time.Duration, max int) error {
+	retryAt, ok, err := m.store.ReserveSend(key, now, window, max)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &RateLimitError{RetryAfter: retryAt.Sub(now)}
+	}
+	return nil
+}
+
+// ipSendKey keeps IP send history apart from phone numbers, which always start with +
+func ipSendKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Verify checks code against the outstanding challenge for phone. A correct code, an
+// expired challenge and the final failed attempt all consume the challenge. Each guess
+// is counted before the code is compared, so concurrent requests cannot get more than
+// the allowed number of guesses.
+func (m *Manager) Verify(phone string, code string) error {
+	challenge, ok, err := m.store.Attempt(phone)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNoChallenge
+	}
+
+	if !time.Now().Before(challenge.ExpiresAt) {
+		m.store.Delete(phone)
+		return ErrExpired
+	}
+	if challenge.Attempts > m.maxAttempts {
+		m.store.Delete(phone)
+		return ErrTooManyAttempts
+	}
+
+	if hmac.Equal(challenge.CodeHash, m.hash(phone, code)) {
+		return m.store.Delete(phone)
+	}
+
+	if challenge.Attempts >= m.maxAttempts {
+		m.store.Delete(phone)
+		return ErrTooManyAttempts
+	}
+	return ErrInvalidCode
+}
+
+func (m *Manager) hash(phone string, code string) []byte {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(phone))
+	mac.Write([]byte{0})
+	mac.Write([]byte(code))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,206 @@
+package otp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testPhone = "+15555550100"
+	testIP    = "203.0.113.7"
+)
+
+func newTestManager(t *testing.T) (*Manager, *MemoryStore) {
+	t.Helper()
+
+	store := NewMemoryStore()
+	manager, err := NewManager(store, []byte("test-secret"))
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	return manager, store
+}
+
+// wrongCode returns a code that differs from code
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "000001"
+	}
+	return "000000"
+}
+
+func TestManagerVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		// guesses are made in order after Start; "code" stands for the issued code
+		guesses []string
+		expire  bool
+		want    []error
+	}{
+		{
+			name:    "correct code",
+			guesses: []string{"code"},
+			want:    []error{nil},
+		},
+		{
+			name:    "code is single use",
+			guesses: []string{"code", "code"},
+			want:    []error{nil, ErrNoChallenge},
+		},
+		{
+			name:    "wrong then correct",
+			guesses: []string{"wrong", "code"},
+			want:    []error{ErrInvalidCode, nil},
+		},
+		{
+			name:    "final wrong guess consumes the challenge",
+			guesses: []string{"wrong", "wrong", "wrong", "wrong", "wrong", "code"},
+			want:    []error{ErrInvalidCode, ErrInvalidCode, ErrInvalidCode, ErrInvalidCode, ErrTooManyAttempts, ErrNoChallenge},
+		},
+		{
+			name:    "correct on the last allowed guess",
+			guesses: []string{"wrong", "wrong", "wrong", "wrong", "code"},
+			want:    []error{ErrInvalidCode, ErrInvalidCode, ErrInvalidCode, ErrInvalidCode, nil},
+		},
+		{
+			name:    "expired code",
+			guesses: []string{"code", "code"},
+			expire:  true,
+			want:    []error{ErrExpired, ErrNoChallenge},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, store := newTestManager(t)
+
+			code, err := manager.Start(testPhone, testIP)
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if tt.expire {
+				challenge, _, _ := store.Get(testPhone)
+				challenge.ExpiresAt = time.Now().Add(-time.Second)
+				store.Put(testPhone, challenge)
+			}
+
+			for i, guess := range tt.guesses {
+				if guess == "code" {
+					guess = code
+				} else {
+					guess = wrongCode(code)
+				}
+				if err := manager.Verify(testPhone, guess); !errors.Is(err, tt.want[i]) {
+					t.Fatalf("guess %d: Verify() error = %v, want %v", i+1, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestManagerVerifyNoChallenge(t *testing.T) {
+	manager, _ := newTestManager(t)
+
+	if err := manager.Verify(testPhone, "123456"); !errors.Is(err, ErrNoChallenge) {
+		t.Fatalf("Verify() error = %v, want ErrNoChallenge", err)
+	}
+}
+
+func TestManagerVerifyConcurrentGuesses(t *testing.T) {
+	manager, _ := newTestManager(t)
+
+	code, err := manager.Start(testPhone, testIP)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var invalid atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errors.Is(manager.Verify(testPhone, wrongCode(code)), ErrInvalidCode) {
+				invalid.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every guess but the last allowed one answers ErrInvalidCode; the rest find the
+	// challenge used up
+	if got := invalid.Load(); got != defaultMaxAttempts-1 {
+		t.Fatalf("%d guesses answered ErrInvalidCode, want %d", got, defaultMaxAttempts-1)
+	}
+}
+
+func TestManagerStartRateLimit(t *testing.T) {
+	manager, _ := newTestManager(t)
+
+	for i := 0; i < defaultMaxSends; i++ {
+		if _, err := manager.Start(testPhone, testIP); err != nil {
+			t.Fatalf("Start() #%d error = %v", i+1, err)
+		}
+	}
+
+	_, err := manager.Start(testPhone, testIP)
+	var rateLimited *RateLimitError
+	if !errors.As(err, &rateLimited) {
+		t.Fatalf("Start() error = %v, want *RateLimitError", err)
+	}
+	if rateLimited.RetryAfter <= 0 || rateLimited.RetryAfter > defaultSendWindow {
+		t.Errorf("RetryAfter = %s, want within the send window", rateLimited.RetryAfter)
+	}
+}
+
+func TestManagerStartConcurrentRateLimit(t *testing.T) {
+	manager, _ := newTestManager(t)
+
+	var wg sync.WaitGroup
+	var started atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := manager.Start(testPhone, testIP)
+			switch {
+			case err == nil:
+				started.Add(1)
+			case !errors.Is(err, ErrRateLimited):
+				t.Errorf("Start() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := started.Load(); got != defaultMaxSends {
+		t.Fatalf("%d codes sent, want %d", got, defaultMaxSends)
+	}
+}
+
+func TestManagerStartIPRateLimit(t *testing.T) {
+	manager, _ := newTestManager(t)
+
+	// One client texting a different number each time
+	phone := func(i int) string { return fmt.Sprintf("+1555555%04d", i) }
+	for i := 0; i < defaultMaxIPSends; i++ {
+		if _, err := manager.Start(phone(i), testIP); err != nil {
+			t.Fatalf("Start() #%d error = %v", i+1, err)
+		}
+	}
+
+	next := phone(defaultMaxIPSends)
+	if _, err := manager.Start(next, testIP); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Start() from the same IP error = %v, want ErrRateLimited", err)
+	}
+	// The refused request did not use up the number's own limit
+	for i := 0; i < defaultMaxSends; i++ {
+		if _, err := manager.Start(next, "198.51.100.1"); err != nil {
+			t.Fatalf("Start() from another IP #%d error = %v", i+1, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package otp
+
+import (
+	"sync"
+	"time"
+)
+
+// Challenge is an outstanding code for one phone number
+type Challenge struct {
+	CodeHash  []byte
+	ExpiresAt time.Time
+	Attempts  int
+}
+
+// Store keeps challenges and send history. It is an interface so several gateway
+// replicas can share a backend; MemoryStore serves a single instance.
+type Store interface {
+	Put(phone string, challenge Challenge) error
+	Get(phone string) (Challenge, bool, error)
+	Delete(phone string) error
+
+	// Attempt counts a guess against the phone's challenge and returns the challenge
+	// with the new count. The increment is atomic, so concurrent guesses never share a
+	// count. It reports false when there is no challenge.
+	Attempt(phone string) (Challenge, bool, error)
+
+	// ReserveSend records a send for key at the given time unless key already has max
+	// sends within window. The check and the record are atomic, so concurrent requests
+	// never exceed max. When key is over its limit it reports false and the time its
+	// oldest send leaves the window. History older than window may be discarded.
+	ReserveSend(key string, at time.Time, window time.Duration, max int) (time.Time, bool, error)
+}
+
+type MemoryStore struct {
+	mu         sync.Mutex
+	challenges map[string]Challenge
+	sends      map[string][]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		challenges: make(map[string]Challenge),
+		sends:      make(map[string][]time.Time),
+	}
+}
+
+func (s *MemoryStore) Put(phone string, challenge Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.challenges[phone] = challenge
+	return nil
+}
+
+func (s *MemoryStore) Get(phone string) (Challenge, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[phone]
+	return challenge, ok, nil
+}
+
+func (s *MemoryStore) Attempt(phone string) (Challenge, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[phone]
+	if !ok {
+		return Challenge{}, false, nil
+	}
+	challenge.Attempts++
+	s.challenges[phone] = challenge
+	return challenge, true, nil
+}
+
+func (s *MemoryStore) Delete(phone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.challenges, phone)
+	return nil
+}
+
+func (s *MemoryStore) ReserveSend(key string, at time.Time, window time.Duration, max int) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sends := sendsAfter(s.sends[key], at.Add(-window))
+	if len(sends) >= max {
+		s.sends[key] = sends
+		return sends[0].Add(window), false, nil
+	}
+	s.sends[key] = append(sends, at)
+
+	// Forget keys whose history has aged out, and stale challenges
+	for k, times := range s.sends {
+		if len(sendsAfter(times, at.Add(-window))) == 0 {
+			delete(s.sends, k)
+		}
+	}
+	for p, challenge := range s.challenges {
+		if at.After(challenge.ExpiresAt) {
+			delete(s.challenges, p)
+		}
+	}
+	return time.Time{}, true, nil
+}
+
+func sendsAfter(times []time.Time, since time.Time) []time.Time {
+	var kept []time.Time
+	for _, t := range times {
+		if t.After(since) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/loop/backend/rider-auth/rest/internals/handlers"
+)
+
+type AccountRoutes struct {
+	mux           *http.ServeMux
+	handler       *handlers.AccountService
+	jwtMiddleware func(http.Handler) http.Handler
+}
+
+func NewAccountRoutes(mux *http.ServeMux, handler *handlers.AccountService, jwtMiddleware func(http.Handler) http.Handler) *AccountRoutes {
+	return &AccountRoutes{
+		mux:           mux,
+		handler:       handler,
+		jwtMiddleware: jwtMiddleware,
+	}
+}
+
+func (r *AccountRoutes) Register() {
+	r.mux.Handle("DELETE /api/auth/rider", r.jwtMiddleware(http.HandlerFunc(r.handler.DeleteRiderHandler)))
+	r.mux.Handle("/api/auth/rider/export", r.jwtMiddleware(http.HandlerFunc(r.handler.ExportRiderHandler)))
+	r.mux.HandleFunc("/api/auth/rider/deletion/cancel", r.handler.CancelDeletionHandler)
+}
@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/loop/backend/rider-auth/lib/authz"
+	"github.com/loop/backend/rider-auth/rest/internals/handlers"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+)
+
+type APIKeyRoutes struct {
+	mux           *http.ServeMux
+	handler       *handlers.APIKeyService
+	jwtMiddleware func(http.Handler) http.Handler
+}
+
+func NewAPIKeyRoutes(mux *http.ServeMux, handler *handlers.APIKeyService, jwtMiddleware func(http.Handler) http.Handler) *APIKeyRoutes {
+	return &APIKeyRoutes{
+		mux:           mux,
+		handler:       handler,
+		jwtMiddleware: jwtMiddleware,
+	}
+}
+
+func (r *APIKeyRoutes) Register() {
+	// Key management is admin only and never accepts an API key itself
+	adminOnly := func(h http.HandlerFunc) http.Handler {
+		return r.jwtMiddleware(middleware.RequireRoles(authz.RoleAdmin)(h))
+	}
+
+	r.mux.Handle("POST /api/admin/api-keys", adminOnly(r.handler.CreateAPIKeyHandler))
+	r.mux.Handle("GET /api/admin/api-keys", adminOnly(r.handler.ListAPIKeysHandler))
+	r.mux.Handle("DELETE /api/admin/api-keys/{id}", adminOnly(r.handler.RevokeAPIKeyHandler))
+}
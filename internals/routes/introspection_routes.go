@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/loop/backend/rider-auth/rest/internals/apikeys"
+	"github.com/loop/backend/rider-auth/rest/internals/handlers"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
+)
+
+type IntrospectionRoutes struct {
+	mux              *http.ServeMux
+	handler          *handlers.IntrospectionService
+	clientMiddleware func(http.Handler) http.Handler
+}
+
+func NewIntrospectionRoutes(mux *http.ServeMux, handler *handlers.IntrospectionService, clientMiddleware func(http.Handler) http.Handler) *IntrospectionRoutes {
+	return &IntrospectionRoutes{
+		mux:              mux,
+		handler:          handler,
+		clientMiddleware: clientMiddleware,
+	}
+}
+
+func (r *IntrospectionRoutes) Register() {
+	// Callers authenticate as clients with an API key holding token:introspect
+	introspect := middleware.RequireScopes(apikeys.ScopeTokenIntrospect)(http.HandlerFunc(r.handler.IntrospectHandler))
+	r.mux.Handle("/api/auth/introspect", r.clientMiddleware(introspect))
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/loop/backend/rider-auth/rest/internals/handlers"
+)
+
+type OIDCRoutes struct {
+	mux     *http.ServeMux
+	handler *handlers.OIDCService
+}
+
+func NewOIDCRoutes(mux *http.ServeMux, handler *handlers.OIDCService) *OIDCRoutes {
+	return &OIDCRoutes{
+		mux:     mux,
+		handler: handler,
+	}
+}
+
+func (r *OIDCRoutes) Register() {
+	r.mux.HandleFunc("/api/auth/oidc/{provider}/start", r.handler.StartOIDCHandler)
+	r.mux.HandleFunc("/api/auth/oidc/{provider}/callback", r.handler.OIDCCallbackHandler)
+}
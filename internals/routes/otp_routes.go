@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/loop/backend/rider-auth/rest/internals/handlers"
+)
+
+type OTPRoutes struct {
+	mux     *http.ServeMux
+	handler *handlers.OTPService
+}
+
+func NewOTPRoutes(mux *http.ServeMux, handler *handlers.OTPService) *OTPRoutes {
+	return &OTPRoutes{
+		mux:     mux,
+		handler: handler,
+	}
+}
+
+func (r *OTPRoutes) Register() {
+	r.mux.HandleFunc("/api/auth/otp/start", r.handler.StartOTPHandler)
+	r.mux.HandleFunc("/api/auth/otp/verify", r.handler.VerifyOTPHandler)
+}
@@ -3,26 +3,34 @@ package routes
 import (
 	"net/http"
 
+	"github.com/loop/backend/rider-auth/rest/internals/apikeys"
 	"github.com/loop/backend/rider-auth/rest/internals/handlers"
 	"github.com/loop/backend/rider-auth/rest/internals/middleware"
 )
 
 type PaymentRoutes struct {
-	mux       *http.ServeMux
-	handler   *handlers.PaymentService
-	secretKey string
+	mux                  *http.ServeMux
+	handler              *handlers.PaymentService
+	authMiddleware       func(http.Handler) http.Handler
+	requireVerifiedEmail bool
 }
 
-func NewPaymentRoutes(mux *http.ServeMux, handler *handlers.PaymentService, secretKey string) *PaymentRoutes {
+func NewPaymentRoutes(mux *http.ServeMux, handler *handlers.PaymentService, authMiddleware func(http.Handler) http.Handler, requireVerifiedEmail bool) *PaymentRoutes {
 	return &PaymentRoutes{
-		mux:       mux,
-		handler:   handler,
-		secretKey: secretKey,
+		mux:                  mux,
+		handler:              handler,
+		authMiddleware:       authMiddleware,
+		requireVerifiedEmail: requireVerifiedEmail,
 	}
 }
 
 func (r *PaymentRoutes) Register() {
-	// Wrap handler with JWT middleware
-	jwtMiddleware := middleware.JWTVerifyMiddleware(r.secretKey)
-	r.mux.Handle("/api/payment/create-checkout-session", jwtMiddleware(http.HandlerFunc(r.handler.CreateCheckoutSessionHandler)))
+	var checkout http.Handler = http.HandlerFunc(r.handler.CreateCheckoutSessionHandler)
+	if r.requireVerifiedEmail {
+		checkout = middleware.RequireVerifiedEmail(checkout)
+	}
+
+	// Partners may call this with an API key instead of a rider's access token
+	checkout = middleware.RequireAPIKeyScopes(apikeys.ScopeCheckoutCreate)(checkout)
+	r.mux.Handle("/api/payment/create-checkout-session", r.authMiddleware(checkout))
 }
@@ -4,17 +4,20 @@ import (
 	"net/http"
 
 	"github.com/loop/backend/rider-auth/rest/internals/handlers"
+	"github.com/loop/backend/rider-auth/rest/internals/middleware"
 )
 
 type AuthRoutes struct {
-	mux     *http.ServeMux
-	handler *handlers.AuthService
+	mux           *http.ServeMux
+	handler       *handlers.AuthService
+	jwtMiddleware func(http.Handler) http.Handler
 }
 
-func NewAuthRoutes(mux *http.ServeMux, handler *handlers.AuthService) *AuthRoutes {
+func NewAuthRoutes(mux *http.ServeMux, handler *handlers.AuthService, jwtMiddleware func(http.Handler) http.Handler) *AuthRoutes {
 	return &AuthRoutes{
-		mux:     mux,
-		handler: handler,
+		mux:           mux,
+		handler:       handler,
+		jwtMiddleware: jwtMiddleware,
 	}
 }
 
@@ -22,5 +25,22 @@ func (r *AuthRoutes) Register() {
 
 	r.mux.HandleFunc("/api/auth/register", r.handler.RegisterHandler)
 	r.mux.HandleFunc("/api/auth/login", r.handler.LoginHandler)
-	r.mux.HandleFunc("/api/auth/rider", r.handler.GetRiderDetailsHandler)
+	r.mux.HandleFunc(middleware.RefreshPath, r.handler.RefreshTokenHandler)
+	r.mux.HandleFunc("/api/auth/logout", r.handler.LogoutHandler)
+	r.mux.HandleFunc("/api/auth/password/forgot", r.handler.ForgotPasswordHandler)
+	r.mux.HandleFunc("/api/auth/password/reset", r.handler.ResetPasswordHandler)
+	r.mux.Handle("/api/auth/password/change", r.jwtMiddleware(http.HandlerFunc(r.handler.ChangePasswordHandler)))
+	r.mux.HandleFunc("/.well-known/jwks.json", r.handler.JWKSHandler)
+	r.mux.HandleFunc("/api/auth/verify-email", r.handler.VerifyEmailHandler)
+	r.mux.Handle("/api/auth/verify-email/resend", r.jwtMiddleware(http.HandlerFunc(r.handler.ResendVerificationEmailHandler)))
+	r.mux.Handle("/api/auth/mfa/enroll", r.jwtMiddleware(http.HandlerFunc(r.handler.MFAEnrollHandler)))
+	r.mux.Handle("/api/auth/mfa/confirm", r.jwtMiddleware(http.HandlerFunc(r.handler.MFAConfirmHandler)))
+	r.mux.Handle("/api/auth/mfa/disable", r.jwtMiddleware(http.HandlerFunc(r.handler.MFADisableHandler)))
+	r.mux.Handle("/api/auth/mfa/backup-codes", r.jwtMiddleware(http.HandlerFunc(r.handler.MFABackupCodesHandler)))
+	r.mux.HandleFunc("/api/auth/mfa/verify", r.handler.MFAVerifyHandler)
+	r.mux.Handle("/api/auth/sessions", r.jwtMiddleware(http.HandlerFunc(r.handler.ListSessionsHandler)))
+	r.mux.Handle("/api/auth/sessions/{id}", r.jwtMiddleware(http.HandlerFunc(r.handler.RevokeSessionHandler)))
+	r.mux.Handle("/api/auth/rider", r.jwtMiddleware(http.HandlerFunc(r.handler.GetRiderDetailsHandler)))
+	r.mux.Handle("PATCH /api/auth/rider", r.jwtMiddleware(http.HandlerFunc(r.handler.UpdateRiderHandler)))
+	r.mux.HandleFunc("/api/auth/rider/email/confirm", r.handler.ConfirmEmailChangeHandler)
 }
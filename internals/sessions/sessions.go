@@ -0,0 +1,295 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/loop/backend/rider-auth/lib/filelock"
+)
+
+// MaxIdle matches the refresh token lifetime: a session not seen for this long can no
+// longer refresh and is forgotten
+const MaxIdle = 7 * 24 * time.Hour
+
+// touchInterval limits how often LastSeenAt is rewritten for a busy session
+const touchInterval = time.Minute
+
+// Session is one login on one device. Its ID is the sid claim of every token issued
+// for the login, so revoking the ID signs the device out.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	DeviceName string    `json:"device_name,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// Store keeps session records. It is an interface so several gateway replicas can
+// share a backend; MemoryStore serves a single instance and FileStore survives restarts.
+type Store interface {
+	Create(session Session) error
+	Get(id string) (Session, bool, error)
+	// List returns the user's sessions, most recently seen first
+	List(userID string) ([]Session, error)
+	// Touch records activity on the session at the given time
+	Touch(id string, at time.Time) error
+	Delete(id string) error
+}
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]Session),
+	}
+}
+
+func (s *MemoryStore) Create(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	createSession(s.sessions, session)
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (Session, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	return session, ok, nil
+}
+
+func (s *MemoryStore) List(userID string) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return listSessions(s.sessions, userID), nil
+}
+
+func (s *MemoryStore) Touch(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	touchSession(s.sessions, id, at)
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// FileStore persists sessions as a JSON file, so the session list and remote sign-out
+// keep working after a restart and across replicas. It follows apikeys.FileStore: the
+// file is re-read whenever it is replaced or modified, and writers hold a lock on a
+// ".lock" file next to it and reload before applying their change.
+type FileStore struct {
+	mu       sync.Mutex
+	path     string
+	loaded   os.FileInfo // the file as last read or written
+	sessions map[string]Session
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:     path,
+		sessions: make(map[string]Session),
+	}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) Create(session Session) error {
+	return s.update(func(sessions map[string]Session) bool {
+		createSession(sessions, session)
+		return true
+	})
+}
+
+func (s *FileStore) Get(id string) (Session, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return Session{}, false, err
+	}
+	session, ok := s.sessions[id]
+	return session, ok, nil
+}
+
+func (s *FileStore) List(userID string) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return listSessions(s.sessions, userID), nil
+}
+
+func (s *FileStore) Touch(id string, at time.Time) error {
+	// Touch runs on every authenticated request but is throttled per session, so check
+	// the current copy before taking the file lock
+	s.mu.Lock()
+	err := s.reload()
+	session, ok := s.sessions[id]
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if !ok || at.Sub(session.LastSeenAt) < touchInterval {
+		return nil
+	}
+
+	return s.update(func(sessions map[string]Session) bool {
+		return touchSession(sessions, id, at)
+	})
+}
+
+func (s *FileStore) Delete(id string) error {
+	return s.update(func(sessions map[string]Session) bool {
+		if _, ok := sessions[id]; !ok {
+			return false
+		}
+		delete(sessions, id)
+		return true
+	})
+}
+
+// update applies change to the current sessions under the file lock and saves them if
+// change reports that it modified them
+func (s *FileStore) update(change func(sessions map[string]Session) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := filelock.Lock(s.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock session file: %w", err)
+	}
+	defer unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if !change(s.sessions) {
+		return nil
+	}
+	return s.save()
+}
+
+// reload reads the file if it changed since the last read. A missing file is an empty store.
+func (s *FileStore) reload() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat session file: %w", err)
+	}
+
+	// Each save renames a new file into place, so a different file with the same
+	// modification time (timestamps are coarser than writes) still counts as a change
+	if s.loaded != nil && os.SameFile(info, s.loaded) && info.ModTime().Equal(s.loaded.ModTime()) && info.Size() == s.loaded.Size() {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read session file: %w", err)
+	}
+
+	sessions := make(map[string]Session)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &sessions); err != nil {
+			return fmt.Errorf("failed to parse session file: %w", err)
+		}
+	}
+
+	s.sessions = sessions
+	s.loaded = info
+	return nil
+}
+
+// save writes the store to a temporary file and renames it over the original
+func (s *FileStore) save() error {
+	data, err := json.MarshalIndent(s.sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".sessions-*")
+	if err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.loaded = info
+	}
+	return nil
+}
+
+// createSession adds session and forgets sessions that can no longer refresh
+func createSession(sessions map[string]Session, session Session) {
+	for id, existing := range sessions {
+		if session.CreatedAt.Sub(existing.LastSeenAt) > MaxIdle {
+			delete(sessions, id)
+		}
+	}
+	sessions[session.ID] = session
+}
+
+func listSessions(sessions map[string]Session, userID string) []Session {
+	var list []Session
+	for _, session := range sessions {
+		if session.UserID == userID && time.Since(session.LastSeenAt) <= MaxIdle {
+			list = append(list, session)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeenAt.After(list[j].LastSeenAt)
+	})
+	return list
+}
+
+// touchSession reports whether LastSeenAt changed
+func touchSession(sessions map[string]Session, id string, at time.Time) bool {
+	session, ok := sessions[id]
+	if !ok || at.Sub(session.LastSeenAt) < touchInterval {
+		return false
+	}
+
+	session.LastSeenAt = at
+	sessions[id] = session
+	return true
+}
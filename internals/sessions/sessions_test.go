@@ -0,0 +1,54 @@
+package sessions
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreSharedBetweenInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	first, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, id := range []string{"s1", "s2"} {
+		if err := first.Create(Session{ID: id, UserID: "rider-1", CreatedAt: now, LastSeenAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := second.List("rider-1")
+	if err != nil || len(list) != 2 {
+		t.Fatalf("second.List() = %v, %v; want both sessions", list, err)
+	}
+
+	// A touch on one replica must not bring back a session deleted on the other
+	if err := second.Delete("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Touch("s2", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := reopened.Get("s1"); err != nil || found {
+		t.Errorf("Get(s1) after delete = %v, %v; want not found", found, err)
+	}
+	session, found, err := reopened.Get("s2")
+	if err != nil || !found {
+		t.Fatalf("Get(s2) = %v, %v; want the session", found, err)
+	}
+	if !session.LastSeenAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("LastSeenAt = %v, want the touch time %v", session.LastSeenAt, now.Add(time.Hour))
+	}
+}